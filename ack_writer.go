@@ -0,0 +1,10 @@
+package fluentlog
+
+// An AckWriter is a client that requires acknowledgement of written entries by the
+// receiver (a.k.a. at-least-once delivery).
+type AckWriter interface {
+	// Removes any written, but unacknowledged, entries and passes them to fn in the
+	// same format as they were originally written. The entries are then written to
+	// the fallback buffer (if any), so that they aren't lost.
+	DrainUnacked(fn func(b []byte))
+}
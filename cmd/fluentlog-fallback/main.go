@@ -0,0 +1,484 @@
+// Inspects and exports the contents of fallback buffers (DirBuffer and FileBuffer).
+//
+// Usage:
+//
+//	fluentlog-fallback stats [flags] <path>...
+//	fluentlog-fallback dump [flags] <path>...
+//	fluentlog-fallback send [flags] <path>...
+//
+// Each path can either be a DirBuffer directory (including any per-owner subdirectories),
+// or a single FileBuffer file. The buffers are only read - never modified.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/webmafia/fluentlog"
+	"github.com/webmafia/fluentlog/forward"
+	"github.com/webmafia/fluentlog/pkg/msgpack"
+	"github.com/webmafia/fluentlog/pkg/msgpack/types"
+)
+
+const usage = `Usage: fluentlog-fallback <command> [flags] <path>...
+
+Commands:
+  stats   Print summary statistics
+  dump    Dump entries as JSON lines or text
+  send    Send entries to a Fluent Forward server
+
+Run "fluentlog-fallback <command> -h" for the flags of a command.
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var err error
+
+	switch cmd, args := os.Args[1], os.Args[2:]; cmd {
+	case "stats":
+		err = stats(args)
+	case "dump":
+		err = dump(args)
+	case "send":
+		err = send(args)
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+}
+
+func stats(args []string) (err error) {
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+	var f filter
+	f.register(fs)
+	fs.Parse(args)
+
+	var (
+		count       int
+		first, last time.Time
+		sevs        [8]int
+		other       int
+	)
+
+	err = readPaths(fs.Args(), func(e *entry) error {
+		if !f.match(e) {
+			return nil
+		}
+
+		count++
+
+		if first.IsZero() || e.ts.Before(first) {
+			first = e.ts
+		}
+
+		if e.ts.After(last) {
+			last = e.ts
+		}
+
+		if sev, ok := e.severity(); ok && int(sev) < len(sevs) {
+			sevs[sev]++
+		} else {
+			other++
+		}
+
+		return nil
+	})
+
+	fmt.Println("Entries:", count)
+
+	if count > 0 {
+		fmt.Println("First:  ", first.Format(time.RFC3339Nano))
+		fmt.Println("Last:   ", last.Format(time.RFC3339Nano))
+
+		for sev, n := range sevs {
+			if n > 0 {
+				fmt.Printf("  %-8s %d\n", fluentlog.Severity(sev), n)
+			}
+		}
+
+		if other > 0 {
+			fmt.Printf("  %-8s %d\n", "(none)", other)
+		}
+	}
+
+	return
+}
+
+func dump(args []string) (err error) {
+	fs := flag.NewFlagSet("dump", flag.ExitOnError)
+	var f filter
+	f.register(fs)
+	format := fs.String("format", "json", "Output format: json or text")
+	tag := fs.String("tag", "fluentlog", "Tag used in text output")
+	fs.Parse(args)
+
+	var write func(e *entry) error
+
+	switch *format {
+	case "json":
+		var b []byte
+
+		write = func(e *entry) (err error) {
+			b = append(b[:0], `{"time":"`...)
+			b = e.ts.AppendFormat(b, time.RFC3339Nano)
+			b = append(b, `","record":`...)
+
+			if b, err = e.record.AppendJson(b); err != nil {
+				return
+			}
+
+			b = append(b, "}\n"...)
+			_, err = os.Stdout.Write(b)
+			return
+		}
+
+	case "text":
+		ascii := forward.NewAsciiFormatter(os.Stdout)
+		var b []byte
+
+		write = func(e *entry) (err error) {
+			b = e.appendMessage(b[:0], *tag)
+			_, err = ascii.Write(b)
+			return
+		}
+
+	default:
+		return fmt.Errorf("unknown format '%s'", *format)
+	}
+
+	return readPaths(fs.Args(), func(e *entry) error {
+		if !f.match(e) {
+			return nil
+		}
+
+		return write(e)
+	})
+}
+
+func send(args []string) (err error) {
+	fs := flag.NewFlagSet("send", flag.ExitOnError)
+	var f filter
+	f.register(fs)
+	addr := fs.String("addr", "localhost:24224", "Address of the Fluent Forward server")
+	tag := fs.String("tag", "fluentlog", "Tag of the sent entries")
+	hostname := fs.String("hostname", "", "Client hostname")
+	sharedKey := fs.String("shared-key", "", "Shared key")
+	username := fs.String("username", "", "Username")
+	password := fs.String("password", "", "Password")
+	useTls := fs.Bool("tls", false, "Use TLS")
+	tlsCa := fs.String("tls-ca", "", "PEM bundle of CAs that verifies the server")
+	tlsInsecure := fs.Bool("tls-insecure", false, "Skip verification of the server certificate")
+	fs.Parse(args)
+
+	var tlsOpt *forward.TLSOptions
+
+	if *useTls {
+		tlsOpt = &forward.TLSOptions{
+			CAFile:             *tlsCa,
+			InsecureSkipVerify: *tlsInsecure,
+		}
+	}
+
+	cli := forward.NewClient(*addr, forward.ClientOptions{
+		Hostname: *hostname,
+		Auth: forward.StaticAuthClient(forward.Credentials{
+			Username:  *username,
+			Password:  *password,
+			SharedKey: *sharedKey,
+		}),
+		TLS:        *useTls,
+		TLSOptions: tlsOpt,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err = cli.Connect(ctx); err != nil {
+		return
+	}
+
+	defer cli.Close()
+
+	var (
+		b     []byte
+		count int
+	)
+
+	err = readPaths(fs.Args(), func(e *entry) (err error) {
+		if !f.match(e) {
+			return
+		}
+
+		b = e.appendMessage(b[:0], *tag)
+
+		if _, err = cli.Write(b); err != nil {
+			return
+		}
+
+		count++
+		return
+	})
+
+	fmt.Fprintln(os.Stderr, "Sent", count, "entries")
+	return
+}
+
+type entry struct {
+	ts     time.Time
+	record msgpack.Value
+}
+
+func (e *entry) severity() (sev fluentlog.Severity, ok bool) {
+	for k, v := range e.record.Map() {
+		if k.Str() == "pri" {
+			switch v.Type() {
+			case types.Uint:
+				return fluentlog.Severity(v.Uint()), true
+			case types.Int:
+				return fluentlog.Severity(v.Int()), true
+			}
+
+			return
+		}
+	}
+
+	return
+}
+
+// Appends the entry in Message mode, i.e. the same format as written by an Instance.
+func (e *entry) appendMessage(b []byte, tag string) []byte {
+	b = msgpack.AppendArrayHeader(b, 3)
+	b = msgpack.AppendString(b, tag)
+	b = msgpack.AppendTimestamp(b, e.ts, msgpack.TsFluentd)
+	return append(b, e.record...)
+}
+
+type filter struct {
+	since    string
+	until    string
+	severity string
+	from, to time.Time
+	maxSev   fluentlog.Severity
+	hasSev   bool
+}
+
+func (f *filter) register(fs *flag.FlagSet) {
+	fs.Func("since", "Only include entries at or after this time (RFC 3339)", func(s string) (err error) {
+		f.from, err = time.Parse(time.RFC3339, s)
+		return
+	})
+	fs.Func("until", "Only include entries before this time (RFC 3339)", func(s string) (err error) {
+		f.to, err = time.Parse(time.RFC3339, s)
+		return
+	})
+	fs.Func("severity", "Only include entries of this severity or more severe (e.g. warn)", func(s string) (err error) {
+		f.maxSev, err = fluentlog.ParseSeverity(s)
+		f.hasSev = true
+		return
+	})
+}
+
+func (f *filter) match(e *entry) bool {
+	if !f.from.IsZero() && e.ts.Before(f.from) {
+		return false
+	}
+
+	if !f.to.IsZero() && !e.ts.Before(f.to) {
+		return false
+	}
+
+	if f.hasSev {
+		sev, ok := e.severity()
+
+		if !ok || sev > f.maxSev {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Reads all entries in all paths, in order.
+func readPaths(paths []string, fn func(e *entry) error) (err error) {
+	if len(paths) == 0 {
+		return errors.New("no path provided")
+	}
+
+	for _, p := range paths {
+		if err = readPath(p, fn); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+func readPath(p string, fn func(e *entry) error) (err error) {
+	fi, err := os.Stat(p)
+
+	if err != nil {
+		return
+	}
+
+	if !fi.IsDir() {
+		return readFile(p, fn)
+	}
+
+	// The ping-pong files of a DirBuffer are read before any per-owner subdirectories.
+	files, err := bufferFiles(p)
+
+	if err != nil {
+		return
+	}
+
+	for _, name := range files {
+		if err = readFile(name, fn); err != nil {
+			return
+		}
+	}
+
+	entries, err := os.ReadDir(p)
+
+	if err != nil {
+		return
+	}
+
+	for _, e := range entries {
+		if e.IsDir() {
+			if err = readPath(filepath.Join(p, e.Name()), fn); err != nil {
+				return
+			}
+		}
+	}
+
+	return
+}
+
+// Returns the existing ping-pong files of a DirBuffer, oldest first. As the files swap roles
+// on each read, and DirBuffer doesn't record which one it was reading from, the least recently
+// modified file is the one holding the oldest entries. Files modified at the same time are
+// returned in the order that a restarted DirBuffer replays them.
+func bufferFiles(dir string) (names []string, err error) {
+	type file struct {
+		name    string
+		modTime time.Time
+	}
+
+	var files []file
+
+	for _, name := range [...]string{"pong.bin", "ping.bin"} {
+		name = filepath.Join(dir, name)
+		fi, err := os.Stat(name)
+
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+
+			return nil, err
+		}
+
+		files = append(files, file{name: name, modTime: fi.ModTime()})
+	}
+
+	slices.SortStableFunc(files, func(a, b file) int {
+		return a.modTime.Compare(b.modTime)
+	})
+
+	for _, f := range files {
+		names = append(names, f.name)
+	}
+
+	return
+}
+
+func readFile(name string, fn func(e *entry) error) (err error) {
+	f, err := os.Open(name)
+
+	if err != nil {
+		return
+	}
+
+	defer f.Close()
+
+	if fi, err := f.Stat(); err != nil || fi.Size() == 0 {
+		return err
+	}
+
+	gz, err := gzip.NewReader(f)
+
+	if err != nil {
+		if err == io.ErrUnexpectedEOF {
+			fmt.Fprintf(os.Stderr, "warning: %s: unexpected end of file\n", name)
+			return nil
+		}
+
+		return fmt.Errorf("%s: %w", name, err)
+	}
+
+	defer gz.Close()
+
+	iter := msgpack.NewIterator(gz)
+
+	var e entry
+
+	for {
+		iter.Flush()
+
+		if err = iter.NextExpectedType(types.Array); err != nil {
+			break
+		}
+
+		if iter.Items() != 2 {
+			err = fmt.Errorf("unexpected array length: expected %d, got %d", 2, iter.Items())
+			break
+		}
+
+		if err = iter.NextExpectedType(types.Ext, types.Int, types.Uint); err != nil {
+			break
+		}
+
+		e.ts = iter.Time()
+
+		if err = iter.NextExpectedType(types.Map); err != nil {
+			break
+		}
+
+		if e.record, err = iter.AppendValue(e.record[:0]); err != nil {
+			break
+		}
+
+		if err = fn(&e); err != nil {
+			return
+		}
+	}
+
+	switch err {
+	case io.EOF:
+		return nil
+
+	// A crashed writer can leave an incomplete gzip stream behind
+	case io.ErrUnexpectedEOF:
+		fmt.Fprintf(os.Stderr, "warning: %s: unexpected end of file\n", name)
+		return nil
+	}
+
+	return fmt.Errorf("%s: %w", name, err)
+}
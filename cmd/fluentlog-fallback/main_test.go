@@ -0,0 +1,237 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/webmafia/fluentlog"
+	"github.com/webmafia/fluentlog/fallback"
+	"github.com/webmafia/fluentlog/pkg/msgpack"
+)
+
+var testTime = time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC)
+
+// Returns an entry as written to a fallback buffer.
+func testEntry(message string, sev fluentlog.Severity, ts time.Time) []byte {
+	b := msgpack.AppendArrayHeader(nil, 2)
+	b = msgpack.AppendTimestamp(b, ts, msgpack.TsFluentd)
+	b = msgpack.AppendMapHeader(b, 2)
+	b = msgpack.AppendString(b, "pri")
+	b = msgpack.AppendUint(b, uint64(sev))
+	b = msgpack.AppendString(b, "message")
+	return msgpack.AppendString(b, message)
+}
+
+func writeEntries(t *testing.T, buf *fallback.DirBuffer, messages ...string) {
+	t.Helper()
+
+	for _, msg := range messages {
+		if _, err := buf.Write(testEntry(msg, fluentlog.INFO, testTime)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// Reads the message of each entry in p.
+func readMessages(t *testing.T, p string) (messages []string) {
+	t.Helper()
+
+	err := readPath(p, func(e *entry) error {
+		msg, _ := e.record.GetStr("message")
+		messages = append(messages, strings.Clone(msg))
+		return nil
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return
+}
+
+func TestReadPath_Order(t *testing.T) {
+	failRead := func(buf *fallback.DirBuffer) {
+		t.Helper()
+
+		err := buf.Reader(func(n int, r io.Reader) error {
+			return errors.New("interrupted")
+		})
+
+		if err == nil {
+			t.Fatal("expected the read to fail")
+		}
+	}
+
+	tests := []struct {
+		name   string
+		reads  int
+		expect []string
+	}{
+		{"ReadingPong", 0, []string{"a", "b", "c"}},
+		{"ReadingPing", 1, []string{"b", "c"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			buf := fallback.NewDirBuffer(dir)
+
+			writeEntries(t, buf, "a")
+
+			// Each successful read swaps the roles of the files
+			for range tt.reads {
+				if err := buf.Reader(func(n int, r io.Reader) error { return nil }); err != nil {
+					t.Fatal(err)
+				}
+
+				writeEntries(t, buf, "b")
+			}
+
+			if tt.reads == 0 {
+				writeEntries(t, buf, "b")
+			}
+
+			// A read that is interrupted leaves its file, while new entries are written to the other
+			failRead(buf)
+			time.Sleep(10 * time.Millisecond)
+			writeEntries(t, buf, "c")
+
+			if err := buf.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := readMessages(t, dir); !slices.Equal(got, tt.expect) {
+				t.Errorf("expected %v, got %v", tt.expect, got)
+			}
+		})
+	}
+}
+
+func TestReadFile_Truncated(t *testing.T) {
+	dir := t.TempDir()
+	buf := fallback.NewDirBuffer(dir)
+
+	name := filepath.Join(dir, "pong.bin")
+
+	// A crashed writer leaves a gzip stream without footer behind, that might also be cut short
+	// within a flushed block
+	sync := func(msg string) int {
+		t.Helper()
+		writeEntries(t, buf, msg)
+
+		if err := buf.Sync(); err != nil {
+			t.Fatal(err)
+		}
+
+		fi, err := os.Stat(name)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return int(fi.Size())
+	}
+
+	first := sync("a")
+	second := sync("b")
+	data, err := os.ReadFile(name)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = buf.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		size   int
+		expect []string
+	}{
+		{"NoFooter", second, []string{"a", "b"}},
+		{"Cut", (first + second) / 2, []string{"a"}},
+		{"Header", 5, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			truncated := filepath.Join(t.TempDir(), "buffer.bin")
+
+			if err := os.WriteFile(truncated, data[:tt.size], 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := readMessages(t, truncated); !slices.Equal(got, tt.expect) {
+				t.Errorf("expected %v, got %v", tt.expect, got)
+			}
+		})
+	}
+}
+
+func TestFilter_Match(t *testing.T) {
+	dir := t.TempDir()
+	buf := fallback.NewDirBuffer(dir)
+
+	entries := []struct {
+		message string
+		sev     fluentlog.Severity
+		ts      time.Time
+	}{
+		{"early error", fluentlog.ERR, testTime.Add(-time.Hour)},
+		{"info", fluentlog.INFO, testTime},
+		{"warning", fluentlog.WARN, testTime.Add(time.Minute)},
+		{"late debug", fluentlog.DEBUG, testTime.Add(time.Hour)},
+	}
+
+	for _, e := range entries {
+		if _, err := buf.Write(testEntry(e.message, e.sev, e.ts)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := buf.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		filter filter
+		expect []string
+	}{
+		{"None", filter{}, []string{"early error", "info", "warning", "late debug"}},
+		{"Since", filter{from: testTime}, []string{"info", "warning", "late debug"}},
+		{"Until", filter{to: testTime.Add(time.Minute)}, []string{"early error", "info"}},
+		{"Range", filter{from: testTime, to: testTime.Add(time.Hour)}, []string{"info", "warning"}},
+		{"Severity", filter{maxSev: fluentlog.WARN, hasSev: true}, []string{"early error", "warning"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+
+			err := readPath(dir, func(e *entry) error {
+				if tt.filter.match(e) {
+					msg, _ := e.record.GetStr("message")
+					got = append(got, strings.Clone(msg))
+				}
+
+				return nil
+			})
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !slices.Equal(got, tt.expect) {
+				t.Errorf("expected %v, got %v", tt.expect, got)
+			}
+		})
+	}
+}
@@ -10,7 +10,6 @@ import (
 	"time"
 
 	"github.com/webmafia/fluentlog/forward"
-	"github.com/webmafia/fluentlog/forward/transport"
 	"github.com/webmafia/fluentlog/pkg/msgpack/types"
 )
 
@@ -36,16 +35,14 @@ func startServer(ctx context.Context) (err error) {
 	})
 
 	return serv.Listen(ctx, func(ctx context.Context, ss *forward.ServerSession) (err error) {
-		var e transport.Entry
-
 		log.Println("connected")
 		defer log.Println("disconnected")
 
 		var i int
 
-		for {
-			if err = ss.Next(&e); err != nil {
-				if errors.Is(err, os.ErrDeadlineExceeded) {
+		for e, err := range ss.Entries() {
+			if err != nil {
+				if errors.Is(err, forward.ErrReadTimeout) {
 					if i > 0 {
 						fmt.Printf("Received %d messages\n", i)
 						i = 0
@@ -53,7 +50,7 @@ func startServer(ctx context.Context) (err error) {
 					continue
 				}
 
-				break
+				return err
 			}
 
 			numFields := e.Record.Items()
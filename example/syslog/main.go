@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+
+	"github.com/webmafia/fluentlog/forward"
+)
+
+// Receives the messages sent by example/cli.
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if err := startServer(ctx); err != nil {
+		log.Println(err)
+	}
+}
+
+func startServer(ctx context.Context) (err error) {
+	serv := forward.NewSyslogServer(forward.SyslogOptions{
+		Address: "127.0.0.1:5140",
+		HandleError: func(err error) {
+			log.Println("client error:", err)
+		},
+	})
+
+	return serv.Listen(ctx, func(ctx context.Context, ss *forward.ServerSession) (err error) {
+		var r forward.LogRecord
+
+		for e, err := range ss.Entries() {
+			if err != nil {
+				return err
+			}
+
+			if err = r.Decode(e); err != nil {
+				return err
+			}
+
+			log.Println(r.Time, r.Tag, r.Severity, r.Message)
+
+			for key, val := range r.Fields() {
+				log.Println("   ", key, "=", val)
+			}
+		}
+
+		return
+	})
+}
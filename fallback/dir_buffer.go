@@ -1,10 +1,14 @@
 package fallback
 
 import (
+	"errors"
+	"fmt"
 	"io"
 	"os"
 	"path"
+	"strings"
 	"sync"
+	"sync/atomic"
 
 	"github.com/klauspost/compress/gzip"
 )
@@ -13,23 +17,294 @@ const perm os.FileMode = 0600
 
 var _ Fallback = (*DirBuffer)(nil)
 
+var (
+	ErrLocked       = errors.New("fallback directory is locked by another owner")
+	ErrNotSupported = errors.New("lock mode not supported on this platform")
+)
+
+type LockMode uint8
+
+const (
+	// The whole directory is locked by a single owner. Any other owner will fail
+	// fast with ErrLocked.
+	LockExclusive LockMode = iota
+
+	// Each owner gets its own subdirectory, that is locked separately. This lets
+	// several processes (or instances) share the same directory. Only supported on
+	// Unix systems, and fails with ErrNotSupported elsewhere.
+	LockPerOwner
+)
+
+type DirBufferOptions struct {
+	LockMode LockMode
+
+	// Name of the owner's subdirectory when using LockPerOwner. Defaults to
+	// "<hostname>-<pid>-<n>", which means that a restarted process will get a
+	// new subdirectory.
+	Owner string
+
+	// Adopt and replay any buffers left behind by dead owners. Only used with
+	// LockPerOwner.
+	Adopt bool
+}
+
 type DirBuffer struct {
+	root    string
 	dir     string
 	rName   string
 	wName   string
 	read    *os.File
 	write   *os.File
 	writeGz *gzip.Writer
+	lock    *os.File
+	opt     DirBufferOptions
 	mu      sync.Mutex
 }
 
-// A disk-based ping-pong buffer. Reads and writes can be done simultaneously.
-func NewDirBuffer(dir string) *DirBuffer {
+var ownerSeq atomic.Uint32
+
+// A disk-based ping-pong buffer. Reads and writes can be done simultaneously. The
+// directory is protected by an advisory lock, so that it can't be corrupted by
+// several owners.
+func NewDirBuffer(dir string, options ...DirBufferOptions) *DirBuffer {
+	var opt DirBufferOptions
+
+	if len(options) > 0 {
+		opt = options[0]
+	}
+
+	root := dir
+
+	if opt.LockMode == LockPerOwner {
+		if opt.Owner == "" {
+			host, _ := os.Hostname()
+			opt.Owner = fmt.Sprintf("%s-%d-%d", host, os.Getpid(), ownerSeq.Add(1))
+		}
+
+		dir = path.Join(root, opt.Owner)
+	}
+
 	return &DirBuffer{
+		root:  root,
 		dir:   dir,
 		rName: path.Join(dir, "ping.bin"),
 		wName: path.Join(dir, "pong.bin"),
+		opt:   opt,
+	}
+}
+
+// Lock acquires the advisory lock of the directory, and adopts any orphaned buffers
+// if configured to. Returns ErrLocked if the directory is owned by someone else. This
+// is done automatically on first use, but can be called beforehand to fail fast.
+func (f *DirBuffer) Lock() (err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.ensureLock()
+}
+
+func (f *DirBuffer) ensureLock() (err error) {
+	if f.lock != nil {
+		return
+	}
+
+	if f.opt.LockMode == LockPerOwner {
+
+		// Live owners would otherwise be mistaken for dead ones
+		if !perOwnerLocks {
+			return ErrNotSupported
+		}
+
+		if err = f.lockOwnerDir(); err != nil {
+			return
+		}
+
+		if f.opt.Adopt {
+			if err = f.adoptOrphans(); err != nil {
+				err = errors.Join(err, f.closeWriteFile(), f.unlock())
+			}
+		}
+
+		return
+	}
+
+	if err = os.MkdirAll(f.dir, 0700); err != nil {
+		return
+	}
+
+	f.lock, err = acquireLock(path.Join(f.dir, "lock"))
+	return
+}
+
+// Locks the owner's subdirectory. A new subdirectory is locked before it gets its name, so
+// that a sibling that is adopting orphans never mistakes it for one.
+func (f *DirBuffer) lockOwnerDir() (err error) {
+	if err = os.MkdirAll(f.root, 0700); err != nil {
+		return
+	}
+
+	for {
+		// Any existing subdirectory of the same owner is reused
+		if f.lock, err = lockDir(f.dir); !os.IsNotExist(err) {
+			return
+		}
+
+		tmp, err := os.MkdirTemp(f.root, ".new-")
+
+		if err != nil {
+			return err
+		}
+
+		lock, err := acquireLock(path.Join(tmp, "lock"))
+
+		if err != nil {
+			os.RemoveAll(tmp)
+			return err
+		}
+
+		if err = os.Rename(tmp, f.dir); err == nil {
+			f.lock = lock
+			return nil
+		}
+
+		releaseLock(lock)
+		os.RemoveAll(tmp)
+
+		// The subdirectory was created by someone else meanwhile
+		if !os.IsExist(err) {
+			return err
+		}
+	}
+}
+
+// Locks an existing directory. As the directory might be removed by an adopting sibling
+// meanwhile, the lock is only kept if its file is still in place.
+func lockDir(dir string) (lock *os.File, err error) {
+	name := path.Join(dir, "lock")
+
+	if lock, err = acquireLock(name); err != nil {
+		return
+	}
+
+	locked, err := lock.Stat()
+
+	if err != nil {
+		releaseLock(lock)
+		return nil, err
+	}
+
+	if cur, err := os.Stat(name); err != nil || !os.SameFile(locked, cur) {
+		releaseLock(lock)
+		return nil, os.ErrNotExist
+	}
+
+	return
+}
+
+// Moves the data of any unlocked (and thereby dead) sibling owners into our write file.
+func (f *DirBuffer) adoptOrphans() (err error) {
+	entries, err := os.ReadDir(f.root)
+
+	if err != nil {
+		return
+	}
+
+	for _, e := range entries {
+		// Subdirectories starting with a dot are still being created
+		if !e.IsDir() || e.Name() == f.opt.Owner || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+
+		if err = f.adopt(path.Join(f.root, e.Name())); err != nil {
+			if err == ErrLocked {
+				err = nil
+				continue
+			}
+
+			return
+		}
+	}
+
+	return
+}
+
+func (f *DirBuffer) adopt(dir string) (err error) {
+	lock, err := lockDir(dir)
+
+	if err != nil {
+		// Already adopted by another sibling
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return
+	}
+
+	defer releaseLock(lock)
+
+	if err = f.ensureWriteFile(); err != nil {
+		return
+	}
+
+	for _, name := range [...]string{"ping.bin", "pong.bin"} {
+		if err = f.recompress(path.Join(dir, name)); err != nil {
+			return
+		}
+	}
+
+	// The adopted data must be durable before the orphan is removed
+	if err = f.writeGz.Flush(); err != nil {
+		return
+	}
+
+	if err = f.write.Sync(); err != nil {
+		return
 	}
+
+	return os.RemoveAll(dir)
+}
+
+// Decompresses a file into our write file. A dead owner might not have closed its gzip
+// stream, in which case everything up to where the file ends abruptly is kept.
+func (f *DirBuffer) recompress(name string) (err error) {
+	file, err := os.Open(name)
+
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return
+	}
+
+	defer file.Close()
+
+	gz, err := gzip.NewReader(file)
+
+	if err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+
+		return
+	}
+
+	defer gz.Close()
+
+	if _, err = io.Copy(f.writeGz, gz); err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+
+	return
+}
+
+func (f *DirBuffer) unlock() (err error) {
+	if f.lock != nil {
+		err = releaseLock(f.lock)
+		f.lock = nil
+	}
+
+	return
 }
 
 func (f *DirBuffer) ensureReadFile() (err error) {
@@ -104,8 +379,8 @@ func (f *DirBuffer) closeWriteFile() (err error) {
 	return
 }
 
-func (d *DirBuffer) initDir() (err error) {
-	return os.MkdirAll(d.dir, 0700)
+func (f *DirBuffer) initDir() (err error) {
+	return f.ensureLock()
 }
 
 // Write implements io.WriteCloser.
@@ -120,16 +395,32 @@ func (f *DirBuffer) Write(p []byte) (n int, err error) {
 	return f.writeGz.Write(p)
 }
 
-func (d *DirBuffer) HasData() (ok bool, err error) {
-	d.mu.Lock()
-	defer d.mu.Unlock()
+// Sync implements Fallback.
+func (f *DirBuffer) Sync() (err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.write == nil {
+		return
+	}
 
-	if err = d.close(); err != nil {
+	if err = f.writeGz.Flush(); err != nil {
 		return
 	}
 
-	rSize := d.fileSize(d.rName)
-	wSize := d.fileSize(d.wName)
+	return f.write.Sync()
+}
+
+func (f *DirBuffer) HasData() (ok bool, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err = f.close(); err != nil {
+		return
+	}
+
+	rSize := f.fileSize(f.rName)
+	wSize := f.fileSize(f.wName)
 
 	// Nothing to read
 	if rSize == 0 && wSize == 0 {
@@ -144,28 +435,28 @@ func (d *DirBuffer) HasData() (ok bool, err error) {
 		}
 
 		// If we came here, it means we have data on both files. Merge them.
-		if err = d.initReadFile(); err != nil {
+		if err = f.initReadFile(); err != nil {
 			return
 		}
 
-		if err = d.initWriteFile(); err != nil {
+		if err = f.initWriteFile(); err != nil {
 			return
 		}
 
-		if _, err = io.Copy(d.write, d.read); err != nil {
+		if _, err = io.Copy(f.write, f.read); err != nil {
 			return
 		}
 
-		if err = d.read.Truncate(0); err != nil {
+		if err = f.read.Truncate(0); err != nil {
 			return
 		}
 
-		err = d.close()
+		err = f.close()
 		return
 	}
 
 	// If we came here, it means we have data in the "wrong" file. Switch them.
-	d.rName, d.wName = d.wName, d.rName
+	f.rName, f.wName = f.wName, f.rName
 	return
 }
 
@@ -179,51 +470,51 @@ func (*DirBuffer) fileSize(name string) int64 {
 	return fi.Size()
 }
 
-func (d *DirBuffer) Reader(fn func(n int, r io.Reader) error) (err error) {
-	if err = d.switchFiles(); err != nil {
+func (f *DirBuffer) Reader(fn func(n int, r io.Reader) error) (err error) {
+	if err = f.switchFiles(); err != nil {
 		return
 	}
 
-	if err = d.ensureReadFile(); err != nil {
+	if err = f.ensureReadFile(); err != nil {
 		return
 	}
 
-	defer d.closeReadFile()
+	defer f.closeReadFile()
 
-	stat, err := d.read.Stat()
+	stat, err := f.read.Stat()
 
 	if err != nil {
 		return
 	}
 
-	if err = fn(int(stat.Size()), d.read); err != nil {
+	if err = fn(int(stat.Size()), f.read); err != nil {
 		return
 	}
 
 	// return nil
-	return d.read.Truncate(0)
+	return f.read.Truncate(0)
 }
 
-func (d *DirBuffer) switchFiles() (err error) {
-	d.mu.Lock()
-	defer d.mu.Unlock()
+func (f *DirBuffer) switchFiles() (err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
 
-	if err = d.close(); err != nil {
+	if err = f.close(); err != nil {
 		return
 	}
 
 	// Do the "ping-pong" switch
-	d.rName, d.wName = d.wName, d.rName
+	f.rName, f.wName = f.wName, f.rName
 
 	return
 }
 
-// Close implements io.WriteCloser.
+// Close implements io.WriteCloser. The directory lock is released.
 func (f *DirBuffer) Close() (err error) {
 	f.mu.Lock()
 	defer f.mu.Unlock()
 
-	return f.close()
+	return errors.Join(f.close(), f.unlock())
 }
 
 func (f *DirBuffer) close() (err error) {
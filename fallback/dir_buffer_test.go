@@ -0,0 +1,137 @@
+//go:build unix
+
+package fallback
+
+import (
+	"io"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/klauspost/compress/gzip"
+)
+
+// Reads all buffered data of a DirBuffer.
+func readAll(t *testing.T, f *DirBuffer) string {
+	t.Helper()
+
+	ok, err := f.HasData()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !ok {
+		return ""
+	}
+
+	var data []byte
+
+	err = f.Reader(func(_ int, r io.Reader) (err error) {
+		gz, err := gzip.NewReader(r)
+
+		if err != nil {
+			return
+		}
+
+		data, err = io.ReadAll(gz)
+		return
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(data)
+}
+
+func TestDirBuffer_LockPerOwner(t *testing.T) {
+	dir := t.TempDir()
+	a := NewDirBuffer(dir, DirBufferOptions{LockMode: LockPerOwner, Owner: "a"})
+	b := NewDirBuffer(dir, DirBufferOptions{LockMode: LockPerOwner, Owner: "b"})
+
+	defer a.Close()
+	defer b.Close()
+
+	if _, err := a.Write([]byte("from a")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := b.Write([]byte("from b")); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := readAll(t, a); got != "from a" {
+		t.Errorf("expected a to read its own data, got %q", got)
+	}
+
+	if got := readAll(t, b); got != "from b" {
+		t.Errorf("expected b to read its own data, got %q", got)
+	}
+
+	if err := NewDirBuffer(dir, DirBufferOptions{LockMode: LockPerOwner, Owner: "a"}).Lock(); err != ErrLocked {
+		t.Errorf("expected ErrLocked of the same owner, got %v", err)
+	}
+}
+
+func TestDirBuffer_Adopt(t *testing.T) {
+	dir := t.TempDir()
+	dead := path.Join(dir, "dead")
+
+	if err := os.MkdirAll(dead, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	// A closed stream in the read file
+	writeGzip(t, path.Join(dead, "ping.bin"), "closed ", true)
+
+	// An unterminated stream in the write file, as its owner crashed after a flush
+	writeGzip(t, path.Join(dead, "pong.bin"), "hello crash ", false)
+
+	f := NewDirBuffer(dir, DirBufferOptions{LockMode: LockPerOwner, Owner: "alive", Adopt: true})
+	defer f.Close()
+
+	if err := f.Lock(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := f.Write([]byte("after")); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := readAll(t, f); got != "closed hello crash after" {
+		t.Errorf("unexpected data: %q", got)
+	}
+
+	if _, err := os.Stat(dead); !os.IsNotExist(err) {
+		t.Errorf("expected the orphan to be removed, got %v", err)
+	}
+}
+
+func writeGzip(t *testing.T, name, data string, close bool) {
+	t.Helper()
+
+	file, err := os.Create(name)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer file.Close()
+
+	gz := gzip.NewWriter(file)
+
+	if _, err = gz.Write([]byte(data)); err != nil {
+		t.Fatal(err)
+	}
+
+	if close {
+		err = gz.Close()
+	} else {
+		err = gz.Flush()
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -6,4 +6,7 @@ type Fallback interface {
 	io.WriteCloser
 	HasData() (ok bool, err error)
 	Reader(fn func(n int, r io.Reader) error) (err error)
+
+	// Flushes any written data to disk, so that it survives a crash.
+	Sync() (err error)
 }
@@ -74,6 +74,21 @@ func (f *FileBuffer) Close() (err error) {
 	return
 }
 
+// Sync implements Fallback.
+func (f *FileBuffer) Sync() (err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.f == nil {
+		return
+	}
+
+	if err = f.w.Flush(); err != nil {
+		return
+	}
+
+	return f.f.Sync()
+}
+
 // HasData implements Fallback.
 func (f *FileBuffer) HasData() (ok bool, err error) {
 	f.mu.Lock()
@@ -0,0 +1,17 @@
+//go:build !unix && !windows
+
+package fallback
+
+import "os"
+
+const perOwnerLocks = false
+
+// Advisory locking is only supported on Unix systems and Windows. Elsewhere the lock file is
+// created, but not locked.
+func acquireLock(name string) (f *os.File, err error) {
+	return os.OpenFile(name, os.O_RDWR|os.O_CREATE, perm)
+}
+
+func releaseLock(f *os.File) (err error) {
+	return f.Close()
+}
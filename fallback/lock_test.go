@@ -0,0 +1,45 @@
+//go:build unix || windows
+
+package fallback
+
+import "testing"
+
+func TestDirBuffer_LockExclusive(t *testing.T) {
+	dir := t.TempDir()
+	a := NewDirBuffer(dir)
+
+	if err := a.Lock(); err != nil {
+		t.Fatal(err)
+	}
+
+	b := NewDirBuffer(dir)
+
+	if err := b.Lock(); err != ErrLocked {
+		t.Fatalf("expected ErrLocked, got %v", err)
+	}
+
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.Lock(); err != nil {
+		t.Fatalf("expected lock once released, got %v", err)
+	}
+
+	b.Close()
+}
+
+func TestDirBuffer_LockPerOwnerSupport(t *testing.T) {
+	f := NewDirBuffer(t.TempDir(), DirBufferOptions{LockMode: LockPerOwner, Adopt: true})
+	defer f.Close()
+
+	err := f.Lock()
+
+	if perOwnerLocks && err != nil {
+		t.Fatal(err)
+	}
+
+	if !perOwnerLocks && err != ErrNotSupported {
+		t.Fatalf("expected ErrNotSupported, got %v", err)
+	}
+}
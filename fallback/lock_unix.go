@@ -0,0 +1,38 @@
+//go:build unix
+
+package fallback
+
+import (
+	"os"
+	"syscall"
+)
+
+// Whether LockPerOwner is supported.
+const perOwnerLocks = true
+
+func acquireLock(name string) (f *os.File, err error) {
+	if f, err = os.OpenFile(name, os.O_RDWR|os.O_CREATE, perm); err != nil {
+		return
+	}
+
+	if err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+
+		if err == syscall.EWOULDBLOCK {
+			err = ErrLocked
+		}
+
+		return nil, err
+	}
+
+	return
+}
+
+func releaseLock(f *os.File) (err error) {
+	if err = syscall.Flock(int(f.Fd()), syscall.LOCK_UN); err != nil {
+		f.Close()
+		return
+	}
+
+	return f.Close()
+}
@@ -0,0 +1,44 @@
+//go:build windows
+
+package fallback
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// Owner directories are renamed and removed while their lock files are open, which Windows
+// doesn't allow.
+const perOwnerLocks = false
+
+func acquireLock(name string) (f *os.File, err error) {
+	if f, err = os.OpenFile(name, os.O_RDWR|os.O_CREATE, perm); err != nil {
+		return
+	}
+
+	var ol windows.Overlapped
+
+	if err = windows.LockFileEx(windows.Handle(f.Fd()), windows.LOCKFILE_EXCLUSIVE_LOCK|windows.LOCKFILE_FAIL_IMMEDIATELY, 0, 1, 0, &ol); err != nil {
+		f.Close()
+
+		if err == windows.ERROR_LOCK_VIOLATION {
+			err = ErrLocked
+		}
+
+		return nil, err
+	}
+
+	return
+}
+
+func releaseLock(f *os.File) (err error) {
+	var ol windows.Overlapped
+
+	if err = windows.UnlockFileEx(windows.Handle(f.Fd()), 0, 1, 0, &ol); err != nil {
+		f.Close()
+		return
+	}
+
+	return f.Close()
+}
@@ -6,6 +6,7 @@ import (
 	"github.com/webmafia/fast"
 	"github.com/webmafia/fluentlog/forward/transport"
 	"github.com/webmafia/fluentlog/internal/gzip"
+	"github.com/webmafia/fluentlog/internal/zstd"
 	"github.com/webmafia/fluentlog/pkg/msgpack"
 )
 
@@ -17,6 +18,7 @@ type AsciiFormatter struct {
 	trans    transport.TransportPhase
 	iterPool msgpack.IterPool
 	gzipPool gzip.Pool
+	zstdPool zstd.Pool
 	buf      []byte
 }
 
@@ -24,7 +26,7 @@ type AsciiFormatter struct {
 // Handy for debugging - do not use in production.
 func NewAsciiFormatter(w io.Writer) *AsciiFormatter {
 	a := &AsciiFormatter{w: w, iter: msgpack.NewIterator(nil)}
-	a.trans.Init(&a.iterPool, &a.gzipPool, func(_ string) error { return nil })
+	a.trans.Init(&a.iterPool, &a.gzipPool, &a.zstdPool, func(_ *transport.Chunk) error { return nil })
 	return a
 }
 
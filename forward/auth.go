@@ -14,6 +14,9 @@ type (
 
 	AuthClient func(ctx context.Context) (Credentials, error)
 	AuthServer func(ctx context.Context, username string) (Credentials, error)
+
+	// Maps the identity of a verified client certificate to a username.
+	CertAuthServer func(ctx context.Context, id CertIdentity) (username string, err error)
 )
 
 func StaticAuthClient(cred Credentials) AuthClient {
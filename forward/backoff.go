@@ -0,0 +1,53 @@
+package forward
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+// Exponential backoff with jitter, used between failed connection attempts.
+type Backoff struct {
+	InitialInterval time.Duration // Interval after the first failed attempt. Defaults to 500 ms.
+	MaxInterval     time.Duration // Interval cap. Defaults to 30 seconds.
+	Multiplier      float64       // Growth factor per failed attempt. Defaults to 2.
+	Jitter          float64       // Randomization factor between 0 and 1. Defaults to 0.2.
+	NoJitter        bool          // Wait the exact intervals, e.g. in tests or with a single upstream.
+}
+
+func (b *Backoff) setDefaults() {
+	if b.InitialInterval <= 0 {
+		b.InitialInterval = 500 * time.Millisecond
+	}
+
+	if b.MaxInterval <= 0 {
+		b.MaxInterval = 30 * time.Second
+	}
+
+	if b.Multiplier < 1 {
+		b.Multiplier = 2
+	}
+
+	if b.NoJitter {
+		b.Jitter = 0
+	} else if b.Jitter <= 0 {
+		b.Jitter = 0.2
+	} else if b.Jitter > 1 {
+		b.Jitter = 1
+	}
+}
+
+// Returns the interval to wait after n consecutive failed attempts.
+func (b *Backoff) interval(n int) time.Duration {
+	d := float64(b.InitialInterval)
+
+	for i := 1; i < n && d < float64(b.MaxInterval); i++ {
+		d *= b.Multiplier
+	}
+
+	d = min(d, float64(b.MaxInterval))
+
+	// Randomize within [d - jitter*d, d + jitter*d]
+	d += d * b.Jitter * (2*rand.Float64() - 1)
+
+	return time.Duration(d)
+}
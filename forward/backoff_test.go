@@ -0,0 +1,157 @@
+package forward
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBackoff_Interval(t *testing.T) {
+	b := Backoff{
+		InitialInterval: 100 * time.Millisecond,
+		MaxInterval:     time.Second,
+		NoJitter:        true,
+	}
+
+	b.setDefaults()
+
+	tests := []struct {
+		failures int
+		interval time.Duration
+	}{
+		{1, 100 * time.Millisecond},
+		{2, 200 * time.Millisecond},
+		{3, 400 * time.Millisecond},
+		{4, 800 * time.Millisecond},
+		{5, time.Second},
+		{100, time.Second},
+	}
+
+	for _, tt := range tests {
+		if got := b.interval(tt.failures); got != tt.interval {
+			t.Errorf("%d failures: expected %s, got %s", tt.failures, tt.interval, got)
+		}
+	}
+}
+
+func TestBackoff_Jitter(t *testing.T) {
+	tests := []struct {
+		jitter   float64
+		min, max time.Duration
+	}{
+		{0.5, 100 * time.Millisecond, 300 * time.Millisecond},
+		{1, 0, 400 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		b := Backoff{
+			InitialInterval: 100 * time.Millisecond,
+			Multiplier:      2,
+			Jitter:          tt.jitter,
+		}
+
+		b.setDefaults()
+		seen := make(map[time.Duration]bool)
+
+		for range 1000 {
+			d := b.interval(2)
+
+			if d < tt.min || d > tt.max {
+				t.Fatalf("jitter %.1f: %s out of [%s, %s]", tt.jitter, d, tt.min, tt.max)
+			}
+
+			seen[d] = true
+		}
+
+		if len(seen) < 2 {
+			t.Errorf("jitter %.1f: expected randomized intervals", tt.jitter)
+		}
+	}
+}
+
+func TestBackoff_Defaults(t *testing.T) {
+	tests := []struct {
+		in     Backoff
+		jitter float64
+	}{
+		{Backoff{}, 0.2},
+		{Backoff{Jitter: 0.5}, 0.5},
+		{Backoff{Jitter: 2}, 1},
+		{Backoff{Jitter: 0.5, NoJitter: true}, 0},
+	}
+
+	for _, tt := range tests {
+		b := tt.in
+		b.setDefaults()
+
+		if b.InitialInterval != 500*time.Millisecond || b.MaxInterval != 30*time.Second || b.Multiplier != 2 || b.Jitter != tt.jitter {
+			t.Errorf("%+v: unexpected defaults %+v", tt.in, b)
+		}
+	}
+}
+
+func TestClient_Backoff(t *testing.T) {
+	const initial = 50 * time.Millisecond
+
+	addr := testAddr(t)
+	cli := NewClient(addr, ClientOptions{
+		Unauthenticated: true,
+		Backoff: Backoff{
+			InitialInterval: initial,
+			NoJitter:        true,
+		},
+	})
+
+	defer cli.Close()
+
+	if _, err := cli.Write(testEntry("test", "a")); err == nil || errors.Is(err, ErrClientDown) {
+		t.Fatalf("expected a failed connection, got %v", err)
+	}
+
+	if s := cli.State(); s != StateDown {
+		t.Fatalf("expected down, got %s", s)
+	}
+
+	// Writes fail fast while backing off
+	start := time.Now()
+
+	if _, err := cli.Write(testEntry("test", "a")); !errors.Is(err, ErrClientDown) {
+		t.Fatalf("expected ErrClientDown, got %v", err)
+	}
+
+	if d := time.Since(start); d >= initial {
+		t.Errorf("expected the write to fail fast, took %s", d)
+	}
+
+	wait, _ := cli.retryIn()
+	time.Sleep(wait)
+
+	// Another failed attempt doubles the interval
+	if err := cli.Connect(context.Background()); err == nil {
+		t.Fatal("expected a failed connection")
+	}
+
+	if wait, _ = cli.retryIn(); wait <= initial || wait > 2*initial {
+		t.Errorf("expected a doubled interval, got %s", wait)
+	}
+
+	testServer(t, ServerOptions{Unauthenticated: true, Address: addr}, func(ctx context.Context, ss *ServerSession) error {
+		return readMessages(ss, make(chan string, 10))
+	})
+
+	time.Sleep(wait)
+
+	if err := cli.Connect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// A successful connection resets the backoff
+	cli.stateMu.Lock()
+	failures := cli.failures
+	cli.stateMu.Unlock()
+
+	if failures != 0 {
+		t.Errorf("expected the failures to be reset, got %d", failures)
+	}
+}
@@ -5,9 +5,11 @@ import (
 	"crypto/rand"
 	"crypto/tls"
 	"errors"
+	"fmt"
 	"io"
 	"log"
 	"net"
+	"sync"
 	"time"
 
 	"github.com/webmafia/fast"
@@ -17,60 +19,276 @@ import (
 
 var _ io.WriteCloser = (*Client)(nil)
 
+// A client is safe for concurrent use. Writes are serialized, so that entries are never
+// interleaved on the wire.
 type Client struct {
 	addr           string
+	mu             sync.Mutex // Guards the connection, reader and writer
 	conn           net.Conn
 	r              msgpack.Iterator
 	w              msgpack.Writer
 	opt            ClientOptions
+	tls            *tlsLoader
 	serverHostname string
 	keepAlive      bool
+	stateMu        sync.Mutex // Guards the fields below
+	state          ClientState
+	failures       int       // Consecutive failed connection attempts
+	retryAt        time.Time // Earliest time for next connection attempt while down
+	lastErr        error
+	bufPool        buffer.Pool
+	chunkId        [16]byte
+	chunkSeq       uint64
+	pending        []*pendingChunk
+	pendingMu      sync.Mutex
+	acked          chan struct{}
+	chunk          *liveChunk // Live entries buffered for compression
+	enc            encoder
 }
 
 type ClientOptions struct {
-	Hostname string
-	Auth     AuthClient
-	TLS      bool
+	Hostname         string
+	Auth             AuthClient
+	TLS              bool          // Connect with TLS, verifying the server certificate by default.
+	TLSOptions       *TLSOptions   // Configuration of TLS, which implies TLS.
+	ConnectTimeout   time.Duration // Timeout for dialing. Defaults to 3 seconds.
+	HandshakeTimeout time.Duration // Timeout for the HELO/PING/PONG handshake. Defaults to 3 seconds.
+	WriteTimeout     time.Duration // Deadline of each write, after which the connection is deemed broken. Defaults to 10 seconds.
+	Backoff          Backoff       // Backoff between failed connection attempts.
+
+	// Skip the HELO/PING/PONG handshake and send entries right after connecting, for
+	// servers without a shared key (e.g. a Fluent Bit forward input without Shared_Key).
+	Unauthenticated bool
+
+	// Request an acknowledgement of every written chunk from the server (a.k.a.
+	// at-least-once delivery). Unacknowledged chunks are retransmitted after reconnect.
+	RequireAck bool
+	AckTimeout time.Duration // Defaults to 30 seconds.
+	MaxUnacked int           // Max number of unacknowledged chunks. Defaults to 4096.
+
+	// Compress live entries, which are then buffered into chunks (a.k.a. CompressedPackedForward
+	// mode) that are sent once reaching ChunkSize, or when FlushInterval has passed.
+	Compression      Compression
+	CompressionLevel int           // Codec-specific level, where zero is the codec's default.
+	ChunkSize        int           // Uncompressed size of a chunk. Defaults to 256 kB.
+	FlushInterval    time.Duration // Max time an entry is buffered. Defaults to 1 second.
+
+	// Called on every state change, with the error that caused it (if any). Must not block.
+	OnStateChange func(state ClientState, err error)
 }
 
+func (opt *ClientOptions) setDefaults() {
+	if opt.ConnectTimeout <= 0 {
+		opt.ConnectTimeout = 3 * time.Second
+	}
+
+	if opt.HandshakeTimeout <= 0 {
+		opt.HandshakeTimeout = 3 * time.Second
+	}
+
+	if opt.WriteTimeout <= 0 {
+		opt.WriteTimeout = 10 * time.Second
+	}
+
+	if opt.AckTimeout <= 0 {
+		opt.AckTimeout = 30 * time.Second
+	}
+
+	if opt.MaxUnacked <= 0 {
+		opt.MaxUnacked = 4096
+	}
+
+	if opt.ChunkSize <= 0 {
+		opt.ChunkSize = 256 * 1024
+	}
+
+	if opt.FlushInterval <= 0 {
+		opt.FlushInterval = time.Second
+	}
+
+	opt.Backoff.setDefaults()
+}
+
+type ClientState uint8
+
+const (
+	StateDisconnected ClientState = iota
+	StateConnecting
+	StateConnected
+
+	// The last connection attempt failed, and any writes will fail fast until the
+	// backoff interval has passed.
+	StateDown
+)
+
+var clientStateStrings = [...]string{
+	"disconnected",
+	"connecting",
+	"connected",
+	"down",
+}
+
+func (s ClientState) String() string {
+	if int(s) >= len(clientStateStrings) {
+		return fmt.Sprintf("(invalid state %d)", s)
+	}
+
+	return clientStateStrings[s]
+}
+
+// Creates a client for a TCP address ("host:port"), or a Unix domain socket ("unix:///path/to/socket").
 func NewClient(addr string, opt ClientOptions) *Client {
-	return &Client{
-		addr: addr,
-		r:    msgpack.NewIterator(nil),
-		w:    msgpack.NewWriter(nil, buffer.NewBuffer(4096)),
-		opt:  opt,
+	opt.setDefaults()
+
+	c := &Client{
+		addr:  addr,
+		r:     msgpack.NewIterator(nil),
+		w:     msgpack.NewWriter(nil, buffer.NewBuffer(4096)),
+		opt:   opt,
+		acked: make(chan struct{}, 1),
+	}
+
+	if opt.TLS || opt.TLSOptions != nil {
+		if opt.TLSOptions == nil {
+			opt.TLSOptions = new(TLSOptions)
+		}
+
+		c.tls = newTlsLoader(opt.TLSOptions)
 	}
+
+	return c
 }
 
+func (c *Client) State() ClientState {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+
+	return c.state
+}
+
+func (c *Client) setState(state ClientState, err error) {
+	c.stateMu.Lock()
+
+	if state == c.state && err == nil {
+		c.stateMu.Unlock()
+		return
+	}
+
+	c.state = state
+	c.stateMu.Unlock()
+
+	if c.opt.OnStateChange != nil {
+		c.opt.OnStateChange(state, err)
+	}
+}
+
+// Returns the time left until the next connection attempt while down, along with the
+// error that caused it.
+func (c *Client) retryIn() (wait time.Duration, err error) {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+
+	if c.state != StateDown {
+		return
+	}
+
+	return time.Until(c.retryAt), c.lastErr
+}
+
+// Connects to the server, and performs the handshake. On failure, the client is marked
+// as down until the backoff interval has passed.
 func (c *Client) Connect(ctx context.Context) (err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.establish(ctx)
+}
+
+func (c *Client) establish(ctx context.Context) (err error) {
+	c.closeConn()
+	c.setState(StateConnecting, nil)
+
+	if err = c.connect(ctx); err != nil {
+		c.closeConn()
+		c.stateMu.Lock()
+		c.failures++
+		c.retryAt = time.Now().Add(c.opt.Backoff.interval(c.failures))
+		c.lastErr = err
+		c.stateMu.Unlock()
+		c.setState(StateDown, err)
+		return
+	}
+
+	c.stateMu.Lock()
+	c.failures = 0
+	c.lastErr = nil
+	c.stateMu.Unlock()
+
+	if c.opt.RequireAck {
+		go c.readAcks(c.conn)
+
+		if err = c.retransmit(); err != nil {
+			c.fail(err)
+			return
+		}
+	}
+
+	c.setState(StateConnected, nil)
+	return
+}
+
+func (c *Client) connect(ctx context.Context) (err error) {
 	var (
 		dial net.Dialer
-		tcp  *net.TCPConn
-		ok   bool
 		cred Credentials
 	)
 
-	if conn, err := dial.DialContext(ctx, "tcp", c.addr); err != nil {
+	network, addr := splitNetwork(c.addr)
+	conn, err := dial.DialContext(ctx, network, addr)
+
+	if err != nil {
 		return errors.Join(ErrFailedConn, err)
-	} else if tcp, ok = conn.(*net.TCPConn); !ok {
-		return errors.New("invalid TCP connection")
 	}
 
-	tcp.SetNoDelay(true)
-
-	if c.opt.TLS {
-		c.conn = tls.Client(tcp, &tls.Config{InsecureSkipVerify: true})
-	} else {
-		c.conn = tcp
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		tcp.SetNoDelay(true)
 	}
 
-	if cred, err = c.opt.Auth(ctx); err != nil {
-		return
+	c.conn = conn
+
+	if c.tls != nil {
+		host, _, _ := net.SplitHostPort(addr)
+		cfg, err := c.tls.config(host)
+
+		if err != nil {
+			return err
+		}
+
+		tlsConn := tls.Client(conn, cfg)
+		c.conn = tlsConn
+
+		if err = tlsConn.HandshakeContext(ctx); err != nil {
+			return err
+		}
 	}
 
 	c.r.Reset(c.conn)
 	c.w.Reset(c.conn)
 
+	if c.opt.Unauthenticated {
+		return
+	}
+
+	if c.opt.Auth != nil {
+		if cred, err = c.opt.Auth(ctx); err != nil {
+			return
+		}
+	}
+
+	if err = c.conn.SetDeadline(time.Now().Add(c.opt.HandshakeTimeout)); err != nil {
+		return
+	}
+
 	var salt [24]byte
 
 	if _, err = rand.Read(salt[:]); err != nil {
@@ -91,59 +309,174 @@ func (c *Client) Connect(ctx context.Context) (err error) {
 		return
 	}
 
-	return
+	return c.conn.SetDeadline(time.Time{})
 }
 
 func (c *Client) ensureConnection() (err error) {
 	if c.conn != nil {
-		return
+		if !c.opt.RequireAck || !c.ackTimedOut() {
+			return
+		}
+
+		// The connection is deemed broken - reconnect and retransmit
+		c.fail(ErrAckTimeout)
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Fail fast while backing off
+	if wait, lastErr := c.retryIn(); wait > 0 {
+		return fmt.Errorf("%w (retrying in %s): %w", ErrClientDown, wait.Round(time.Millisecond), lastErr)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.opt.ConnectTimeout)
 	defer cancel()
 
-	// Todo: Retry (https://github.com/cenkalti/backoff)
-	if err = c.Connect(ctx); err != nil {
-		return
-	}
+	return c.establish(ctx)
+}
 
-	return
+// Connects unless already connected.
+func (c *Client) connectIfNeeded() (err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.ensureConnection()
+}
+
+// Sets the deadline of the next write, so that a stuck server can't block forever.
+func (c *Client) setWriteDeadline() error {
+	return c.conn.SetWriteDeadline(time.Now().Add(c.opt.WriteTimeout))
 }
 
 func (c *Client) Write(b []byte) (n int, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.opt.Compression != CompressNone {
+		return c.writeCompressed(b)
+	}
+
+	return c.write(b)
+}
+
+func (c *Client) write(b []byte) (n int, err error) {
 	if err = c.ensureConnection(); err != nil {
 		return
 	}
 
-	return c.conn.Write(b)
+	if err = c.setWriteDeadline(); err != nil {
+		c.fail(err)
+		return
+	}
+
+	if c.opt.RequireAck {
+		n, err = c.writeWithAck(b)
+	} else {
+		n, err = c.conn.Write(b)
+	}
+
+	if err != nil && err != ErrTooManyUnacked {
+		c.fail(err)
+	}
+
+	return
+}
+
+// Closes a broken connection, so that the next write will reconnect.
+func (c *Client) fail(err error) {
+	c.closeConn()
+	c.setState(StateDisconnected, err)
+}
+
+func (c *Client) closeConn() (err error) {
+	if c.conn == nil {
+		return nil
+	}
+
+	err = c.conn.Close()
+	c.conn = nil
+	return
 }
 
 func (c *Client) Reconnect() (err error) {
 	log.Println("Reconnecting...")
 
-	if err = c.Close(); err != nil {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err = c.close(); err != nil {
 		return
 	}
 
 	return c.ensureConnection()
 }
 
-// Close implements io.WriteCloser.
+// Close implements io.WriteCloser. Any outstanding acknowledgements are waited for.
 func (c *Client) Close() (err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.close()
+}
+
+func (c *Client) close() (err error) {
+	c.stopChunkTimer()
+
+	// On failure, any buffered entries are kept for DrainUnacked
+	if err = c.flushChunk(); err != nil {
+		return
+	}
+
 	if c.conn == nil {
 		return nil
 	}
 
-	err = c.conn.Close()
-	c.conn = nil
+	if c.opt.RequireAck {
+		c.waitForAcks()
+	}
+
+	err = c.closeConn()
+	c.setState(StateDisconnected, nil)
 	return
 }
 
 func (c *Client) WriteBatch(tag string, size int, r io.Reader) (err error) {
+	c.mu.Lock()
+	p, conn, err := c.writeBatch(tag, size, r)
+	c.mu.Unlock()
+
+	if err != nil || p == nil {
+		return
+	}
+
+	// The batch is not released from the fallback buffer until acknowledged. Other
+	// writes may proceed meanwhile.
+	if err = c.waitForAck(p); err != nil {
+		c.mu.Lock()
+
+		// Unless the connection has already been replaced
+		if c.conn == conn {
+			c.fail(err)
+		}
+
+		c.mu.Unlock()
+	}
+
+	return
+}
+
+func (c *Client) writeBatch(tag string, size int, r io.Reader) (p *pendingChunk, conn net.Conn, err error) {
+	if err = c.flushChunk(); err != nil {
+		return
+	}
+
 	if err = c.ensureConnection(); err != nil {
 		return
 	}
 
+	if err = c.setWriteDeadline(); err != nil {
+		c.fail(err)
+		return
+	}
+
 	c.w.WriteArrayHeader(3)
 
 	// 1. Tag (string)
@@ -151,13 +484,37 @@ func (c *Client) WriteBatch(tag string, size int, r io.Reader) (err error) {
 
 	// 2. Entries (CompressedMessagePackEventStream)
 	if err = c.w.WriteBinaryReader(size, r); err != nil {
+		c.fail(err)
 		return
 	}
 
 	// 3. Options
-	c.w.WriteMapHeader(1)
+	if c.opt.RequireAck {
+		p = &pendingChunk{
+			id:     c.nextChunkId(),
+			sentAt: time.Now(),
+			done:   make(chan struct{}),
+		}
+
+		c.addPending(p)
+		c.w.WriteMapHeader(2)
+		c.w.WriteString("chunk")
+		c.w.WriteString(p.id)
+	} else {
+		c.w.WriteMapHeader(1)
+	}
+
 	c.w.WriteString("compressed")
 	c.w.WriteString("gzip")
 
-	return c.w.Flush()
+	if err = c.w.Flush(); err != nil {
+		if p != nil {
+			c.removePending(p)
+		}
+
+		c.fail(err)
+		return nil, nil, err
+	}
+
+	return p, c.conn, nil
 }
@@ -0,0 +1,280 @@
+package forward
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"net"
+	"time"
+
+	"github.com/webmafia/fast/buffer"
+	"github.com/webmafia/fluentlog/pkg/msgpack"
+	"github.com/webmafia/fluentlog/pkg/msgpack/types"
+)
+
+// A chunk that has been written, but not yet acknowledged by the server.
+type pendingChunk struct {
+	id      string
+	data    *buffer.Buffer // Nil for batches, as they can't be retransmitted from memory
+	origLen int            // Length of the entry as originally written
+	entries *liveChunk     // Original entries of a compressed chunk
+	sentAt  time.Time
+	done    chan struct{} // Closed when acknowledged
+}
+
+// Returns a unique chunk ID, consisting of a random prefix per client and a counter.
+func (c *Client) nextChunkId() string {
+	if c.chunkSeq == 0 {
+		rand.Read(c.chunkId[:8])
+	}
+
+	c.chunkSeq++
+	binary.BigEndian.PutUint64(c.chunkId[8:], c.chunkSeq)
+
+	return base64.StdEncoding.EncodeToString(c.chunkId[:])
+}
+
+func (c *Client) addPending(p *pendingChunk) {
+	c.pendingMu.Lock()
+	c.pending = append(c.pending, p)
+	c.pendingMu.Unlock()
+}
+
+func (c *Client) removePending(p *pendingChunk) {
+	c.pendingMu.Lock()
+	defer c.pendingMu.Unlock()
+
+	for i := range c.pending {
+		if c.pending[i] == p {
+			c.pending = append(c.pending[:i], c.pending[i+1:]...)
+			return
+		}
+	}
+}
+
+func (c *Client) numPending() int {
+	c.pendingMu.Lock()
+	defer c.pendingMu.Unlock()
+
+	return len(c.pending)
+}
+
+// Returns the number of written, but not yet acknowledged, chunks.
+func (c *Client) Unacked() int {
+	return c.numPending()
+}
+
+// Whether the oldest unacknowledged chunk has timed out.
+func (c *Client) ackTimedOut() bool {
+	c.pendingMu.Lock()
+	defer c.pendingMu.Unlock()
+
+	return len(c.pending) > 0 && time.Since(c.pending[0].sentAt) > c.opt.AckTimeout
+}
+
+func (c *Client) ack(id string) {
+	c.pendingMu.Lock()
+	defer c.pendingMu.Unlock()
+
+	for i, p := range c.pending {
+		if p.id != id {
+			continue
+		}
+
+		c.pending = append(c.pending[:i], c.pending[i+1:]...)
+
+		if p.done != nil {
+			close(p.done)
+		}
+
+		c.releasePending(p)
+		break
+	}
+
+	select {
+	case c.acked <- struct{}{}:
+	default:
+	}
+}
+
+// Writes an entry with a chunk option. Entries in Message mode (3 items) and Forward
+// mode (2 items) are supported - anything else is written as-is.
+func (c *Client) writeWithAck(b []byte) (n int, err error) {
+	if len(b) == 0 || (b[0] != 0x92 && b[0] != 0x93) {
+		return c.conn.Write(b)
+	}
+
+	if c.numPending() >= c.opt.MaxUnacked {
+		return 0, ErrTooManyUnacked
+	}
+
+	p := &pendingChunk{
+		id:      c.nextChunkId(),
+		data:    c.bufPool.Get(),
+		origLen: len(b),
+		sentAt:  time.Now(),
+	}
+
+	p.data.B = append(p.data.B, b[0]+1)
+	p.data.B = append(p.data.B, b[1:]...)
+	p.data.B = msgpack.AppendMapHeader(p.data.B, 1)
+	p.data.B = msgpack.AppendString(p.data.B, "chunk")
+	p.data.B = msgpack.AppendString(p.data.B, p.id)
+
+	c.addPending(p)
+
+	if _, err = c.conn.Write(p.data.B); err != nil {
+
+		// The caller will treat the entry as not written, so it must not be retransmitted
+		c.removePending(p)
+		c.bufPool.Put(p.data)
+		return
+	}
+
+	return len(b), nil
+}
+
+// Waits for the acknowledgement of a batch.
+func (c *Client) waitForAck(p *pendingChunk) (err error) {
+	timer := time.NewTimer(c.opt.AckTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-p.done:
+		return
+
+	case <-timer.C:
+		c.removePending(p)
+		return ErrAckTimeout
+	}
+}
+
+// Retransmits any unacknowledged chunks after a reconnect. The chunks are copied, so that
+// acknowledgements can be read (and the chunks released) while they are being written.
+func (c *Client) retransmit() (err error) {
+	buf := c.bufPool.Get()
+	defer c.bufPool.Put(buf)
+
+	c.pendingMu.Lock()
+	now := time.Now()
+
+	for _, p := range c.pending {
+		if p.data == nil {
+			continue
+		}
+
+		buf.B = append(buf.B, p.data.B...)
+		p.sentAt = now
+	}
+
+	c.pendingMu.Unlock()
+
+	if len(buf.B) == 0 {
+		return
+	}
+
+	if err = c.setWriteDeadline(); err != nil {
+		return
+	}
+
+	_, err = c.conn.Write(buf.B)
+	return
+}
+
+// Reads acknowledgements from the server until the connection is closed.
+func (c *Client) readAcks(conn net.Conn) {
+	defer conn.Close()
+
+	iter := msgpack.NewIterator(conn)
+
+	for {
+		iter.Flush()
+
+		if err := iter.NextExpectedType(types.Map); err != nil {
+			return
+		}
+
+		for range iter.Items() {
+			if err := iter.NextExpectedType(types.Str); err != nil {
+				return
+			}
+
+			key := iter.Str()
+
+			if !iter.Next() {
+				return
+			}
+
+			if key == "ack" {
+				c.ack(iter.Str())
+			} else {
+				iter.Skip()
+			}
+		}
+	}
+}
+
+// DrainUnacked implements fluentlog.AckWriter. As long as there is a connection, it
+// waits (up to the ack timeout) for any outstanding acknowledgements. Any entries that
+// are still unacknowledged are then removed, and passed to fn in the same format as they
+// were originally written.
+func (c *Client) DrainUnacked(fn func(b []byte)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Any buffered entries are sent first, if possible
+	c.flushChunk()
+	c.waitForAcks()
+
+	c.pendingMu.Lock()
+	pending := c.pending
+	c.pending = nil
+	c.pendingMu.Unlock()
+
+	for _, p := range pending {
+		if p.entries != nil {
+			p.entries.drain(fn)
+		} else if p.data != nil {
+			p.data.B[0]--
+			fn(p.data.B[:p.origLen])
+		}
+
+		c.releasePending(p)
+	}
+
+	if c.chunk != nil {
+		c.stopChunkTimer()
+		c.chunk.drain(fn)
+		c.bufPool.Put(c.chunk.buf)
+		c.chunk = nil
+	}
+}
+
+func (c *Client) releasePending(p *pendingChunk) {
+	if p.data != nil {
+		c.bufPool.Put(p.data)
+	}
+
+	if p.entries != nil {
+		c.bufPool.Put(p.entries.buf)
+	}
+}
+
+// Waits (up to the ack timeout) for any outstanding acknowledgements, as long as there
+// is a connection.
+func (c *Client) waitForAcks() {
+	if c.conn == nil {
+		return
+	}
+
+	deadline := time.NewTimer(c.opt.AckTimeout)
+	defer deadline.Stop()
+
+	for c.numPending() > 0 {
+		select {
+		case <-c.acked:
+		case <-deadline.C:
+			return
+		}
+	}
+}
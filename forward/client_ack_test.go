@@ -0,0 +1,240 @@
+package forward
+
+import (
+	"bytes"
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/webmafia/fluentlog/forward/transport"
+)
+
+func TestClient_Ack(t *testing.T) {
+	msgs := make(chan string, 10)
+	chunks := make(chan string, 10)
+
+	_, addr := testServer(t, ServerOptions{Unauthenticated: true}, func(ctx context.Context, ss *ServerSession) error {
+		ss.OnChunk(func(c *transport.Chunk) error {
+			chunks <- c.ID
+			return nil
+		})
+
+		return readMessages(ss, msgs)
+	})
+
+	cli := NewClient(addr, ClientOptions{Unauthenticated: true, RequireAck: true})
+	defer cli.Close()
+
+	for _, msg := range []string{"a", "b", "c"} {
+		if _, err := cli.Write(testEntry("test", msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ids := make(map[string]bool)
+
+	for _, msg := range []string{"a", "b", "c"} {
+		if got := receive(t, msgs); got != msg {
+			t.Errorf("expected %q, got %q", msg, got)
+		}
+
+		ids[receive(t, chunks)] = true
+	}
+
+	if len(ids) != 3 || ids[""] {
+		t.Errorf("expected 3 unique chunk IDs, got %v", ids)
+	}
+
+	waitFor(t, "acknowledgements", func() bool {
+		return cli.Unacked() == 0
+	})
+}
+
+func TestClient_RetransmitAfterDrop(t *testing.T) {
+	msgs := make(chan string, 10)
+	var sessions atomic.Int32
+
+	_, addr := testServer(t, ServerOptions{Unauthenticated: true, ManualAck: true}, func(ctx context.Context, ss *ServerSession) error {
+		first := sessions.Add(1) == 1
+
+		for e, err := range ss.Entries() {
+			if err != nil {
+				return err
+			}
+
+			o, err := e.Clone()
+
+			if err != nil {
+				return err
+			}
+
+			msgs <- o.Record.Get("message").Str()
+
+			// The first session drops the connection without acknowledging anything
+			if first {
+				return nil
+			}
+
+			if err = ss.Ack(o.Chunk); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	cli := NewClient(addr, ClientOptions{
+		Unauthenticated: true,
+		RequireAck:      true,
+		AckTimeout:      100 * time.Millisecond,
+	})
+
+	defer cli.Close()
+
+	if _, err := cli.Write(testEntry("test", "a")); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := receive(t, msgs); got != "a" {
+		t.Fatalf("expected \"a\", got %q", got)
+	}
+
+	// Once the ack has timed out, the next write reconnects and retransmits
+	time.Sleep(150 * time.Millisecond)
+
+	if _, err := cli.Write(testEntry("test", "b")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, msg := range []string{"a", "b"} {
+		if got := receive(t, msgs); got != msg {
+			t.Errorf("expected %q after reconnect, got %q", msg, got)
+		}
+	}
+
+	waitFor(t, "acknowledgements", func() bool {
+		return cli.Unacked() == 0
+	})
+
+	if n := sessions.Load(); n != 2 {
+		t.Errorf("expected 2 sessions, got %d", n)
+	}
+}
+
+func TestClient_DrainUnacked(t *testing.T) {
+	msgs := make(chan string, 10)
+
+	// Chunks are never acknowledged
+	_, addr := testServer(t, ServerOptions{Unauthenticated: true, ManualAck: true}, func(ctx context.Context, ss *ServerSession) error {
+		return readMessages(ss, msgs)
+	})
+
+	cli := NewClient(addr, ClientOptions{
+		Unauthenticated: true,
+		RequireAck:      true,
+		AckTimeout:      100 * time.Millisecond,
+		MaxUnacked:      2,
+	})
+
+	defer cli.Close()
+
+	written := [][]byte{testEntry("test", "a"), testEntry("test", "b")}
+
+	for _, b := range written {
+		if _, err := cli.Write(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := cli.Write(testEntry("test", "c")); err != ErrTooManyUnacked {
+		t.Errorf("expected ErrTooManyUnacked, got %v", err)
+	}
+
+	var drained [][]byte
+
+	cli.DrainUnacked(func(b []byte) {
+		drained = append(drained, bytes.Clone(b))
+	})
+
+	if len(drained) != len(written) {
+		t.Fatalf("expected %d drained entries, got %d", len(written), len(drained))
+	}
+
+	for i := range written {
+		if !bytes.Equal(drained[i], written[i]) {
+			t.Errorf("entry %d: expected it as originally written, got %x", i, drained[i])
+		}
+	}
+
+	if n := cli.Unacked(); n != 0 {
+		t.Errorf("expected no unacked chunks after draining, got %d", n)
+	}
+}
+
+func TestClient_RetransmitMany(t *testing.T) {
+	const n = 10000
+
+	var (
+		sessions atomic.Int32
+		received atomic.Int32
+	)
+
+	_, addr := testServer(t, ServerOptions{Unauthenticated: true, ManualAck: true}, func(ctx context.Context, ss *ServerSession) error {
+		first := sessions.Add(1) == 1
+
+		for e, err := range ss.Entries() {
+			if err != nil {
+				return err
+			}
+
+			e.Record.Skip()
+
+			// The first session drops the connection without acknowledging anything
+			if first {
+				if received.Add(1) == n {
+					return nil
+				}
+
+				continue
+			}
+
+			if err = ss.Ack(e.Chunk.Seq); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	cli := NewClient(addr, ClientOptions{
+		Unauthenticated: true,
+		RequireAck:      true,
+		WriteTimeout:    2 * time.Second,
+		MaxUnacked:      2 * n,
+	})
+
+	defer cli.Close()
+
+	for range n {
+		if _, err := cli.Write(testEntry("test", "a")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	waitFor(t, "entries", func() bool {
+		return received.Load() == n
+	})
+
+	// Once the connection has been dropped, a write fails and the next one reconnects. The
+	// acknowledgements of the retransmitted chunks must be read while they are still being
+	// retransmitted, as the server otherwise blocks on writing them.
+	waitFor(t, "reconnect", func() bool {
+		_, err := cli.Write(testEntry("test", "b"))
+		return err == nil && sessions.Load() == 2
+	})
+
+	waitFor(t, "acknowledgements", func() bool {
+		return cli.Unacked() == 0
+	})
+}
@@ -0,0 +1,297 @@
+package forward
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/webmafia/fast/buffer"
+	"github.com/webmafia/fluentlog/pkg/msgpack"
+)
+
+// Compression of live entries, which are then buffered into CompressedPackedForward chunks.
+type Compression uint8
+
+const (
+	CompressNone Compression = iota
+	CompressGzip
+	CompressZstd
+)
+
+var compressionStrings = [...]string{
+	"none",
+	"gzip",
+	"zstd",
+}
+
+func (c Compression) String() string {
+	if int(c) >= len(compressionStrings) {
+		return fmt.Sprintf("(invalid compression %d)", c)
+	}
+
+	return compressionStrings[c]
+}
+
+type encoder interface {
+	io.WriteCloser
+	Reset(w io.Writer)
+}
+
+// Live entries that are buffered into a compressed chunk, until it's either full or
+// the flush interval has passed.
+type liveChunk struct {
+	tag   string
+	strip int            // Length of the array header and tag of each entry
+	buf   *buffer.Buffer // Entries as originally written, in Message mode
+	ends  []int          // End offset of each entry in buf
+	timer *time.Timer
+}
+
+// Passes each entry to fn, in the same format as it was originally written.
+func (ch *liveChunk) drain(fn func(b []byte)) {
+	var start int
+
+	for _, end := range ch.ends {
+		fn(ch.buf.B[start:end])
+		start = end
+	}
+}
+
+// Returns the tag of an entry in Message mode, along with the offset after it.
+func messageTag(b []byte) (tag string, offset int, ok bool) {
+	if len(b) == 0 || b[0] != 0x93 {
+		return
+	}
+
+	if tag, offset, err := msgpack.ReadString(b, 1); err == nil {
+		return tag, offset, true
+	}
+
+	return
+}
+
+// Buffers an entry in Message mode into the current chunk. Anything else is written as-is,
+// after any buffered entries.
+func (c *Client) writeCompressed(b []byte) (n int, err error) {
+	tag, offset, ok := messageTag(b)
+
+	if !ok {
+		if err = c.flushChunk(); err != nil {
+			return
+		}
+
+		return c.write(b)
+	}
+
+	if ch := c.chunk; ch != nil && (ch.tag != tag || ch.buf.Len() >= c.opt.ChunkSize) {
+		if err = c.flushChunk(); err != nil {
+			return
+		}
+	}
+
+	if c.chunk == nil {
+		ch := &liveChunk{
+			tag:   strings.Clone(tag),
+			strip: offset,
+			buf:   c.bufPool.Get(),
+		}
+
+		ch.timer = time.AfterFunc(c.opt.FlushInterval, func() { c.flushAfterInterval(ch) })
+		c.chunk = ch
+	}
+
+	c.chunk.buf.B = append(c.chunk.buf.B, b...)
+	c.chunk.ends = append(c.chunk.ends, c.chunk.buf.Len())
+
+	// On failure, the chunk is kept and retried on the next write
+	if c.chunk.buf.Len() >= c.opt.ChunkSize {
+		c.flushChunk()
+	}
+
+	return len(b), nil
+}
+
+func (c *Client) flushAfterInterval(ch *liveChunk) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.chunk != ch {
+		return
+	}
+
+	if err := c.flushChunk(); err != nil {
+		ch.timer.Reset(c.opt.FlushInterval)
+	}
+}
+
+func (c *Client) stopChunkTimer() {
+	if c.chunk != nil {
+		c.chunk.timer.Stop()
+	}
+}
+
+// Flush sends any buffered entries as a compressed chunk.
+func (c *Client) Flush() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.flushChunk()
+}
+
+// Sends the current chunk, if any. On failure, the chunk is kept.
+func (c *Client) flushChunk() (err error) {
+	ch := c.chunk
+
+	if ch == nil {
+		return
+	}
+
+	if err = c.ensureConnection(); err != nil {
+		return
+	}
+
+	if c.opt.RequireAck && c.numPending() >= c.opt.MaxUnacked {
+		return ErrTooManyUnacked
+	}
+
+	var p *pendingChunk
+
+	if c.opt.RequireAck {
+		p = &pendingChunk{
+			id:      c.nextChunkId(),
+			entries: ch,
+		}
+	}
+
+	frame := c.bufPool.Get()
+
+	if err = c.appendChunk(frame, ch, p); err != nil {
+		c.bufPool.Put(frame)
+		return
+	}
+
+	if err = c.setWriteDeadline(); err != nil {
+		c.bufPool.Put(frame)
+		c.fail(err)
+		return
+	}
+
+	if p != nil {
+		p.data = frame
+		p.sentAt = time.Now()
+		c.addPending(p)
+	}
+
+	if _, err = c.conn.Write(frame.B); err != nil {
+		if p != nil {
+			c.removePending(p)
+		}
+
+		c.bufPool.Put(frame)
+		c.fail(err)
+		return
+	}
+
+	ch.timer.Stop()
+	c.chunk = nil
+
+	// Chunks awaiting acknowledgement are released once acknowledged
+	if p == nil {
+		c.bufPool.Put(frame)
+		c.bufPool.Put(ch.buf)
+	}
+
+	return
+}
+
+// Appends a chunk in CompressedPackedForward mode.
+func (c *Client) appendChunk(dst *buffer.Buffer, ch *liveChunk, p *pendingChunk) (err error) {
+	comp := c.bufPool.Get()
+	defer c.bufPool.Put(comp)
+
+	enc, err := c.encoder(comp)
+
+	if err != nil {
+		return
+	}
+
+	var start int
+
+	// Each entry is written as an array of 2 items (timestamp + record)
+	for _, end := range ch.ends {
+		if _, err = enc.Write([]byte{0x92}); err != nil {
+			return
+		}
+
+		if _, err = enc.Write(ch.buf.B[start+ch.strip : end]); err != nil {
+			return
+		}
+
+		start = end
+	}
+
+	if err = enc.Close(); err != nil {
+		return
+	}
+
+	dst.B = msgpack.AppendArrayHeader(dst.B, 3)
+	dst.B = msgpack.AppendString(dst.B, ch.tag)
+	dst.B = msgpack.AppendBinary(dst.B, comp.B)
+
+	if p != nil {
+		dst.B = msgpack.AppendMapHeader(dst.B, 3)
+		dst.B = msgpack.AppendString(dst.B, "chunk")
+		dst.B = msgpack.AppendString(dst.B, p.id)
+	} else {
+		dst.B = msgpack.AppendMapHeader(dst.B, 2)
+	}
+
+	dst.B = msgpack.AppendString(dst.B, "size")
+	dst.B = msgpack.AppendInt(dst.B, int64(len(ch.ends)))
+	dst.B = msgpack.AppendString(dst.B, "compressed")
+	dst.B = msgpack.AppendString(dst.B, c.opt.Compression.String())
+
+	return
+}
+
+// Returns the encoder of the client, reset to write to w.
+func (c *Client) encoder(w io.Writer) (enc encoder, err error) {
+	if c.enc != nil {
+		c.enc.Reset(w)
+		return c.enc, nil
+	}
+
+	switch c.opt.Compression {
+
+	case CompressGzip:
+		level := c.opt.CompressionLevel
+
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+
+		c.enc, err = gzip.NewWriterLevel(w, level)
+
+	case CompressZstd:
+		opts := []zstd.EOption{zstd.WithEncoderConcurrency(1)}
+
+		if c.opt.CompressionLevel != 0 {
+			opts = append(opts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(c.opt.CompressionLevel)))
+		}
+
+		c.enc, err = zstd.NewWriter(w, opts...)
+
+	default:
+		err = fmt.Errorf("%w: compression %s", ErrNotSupported, c.opt.Compression)
+
+	}
+
+	if err != nil {
+		c.enc = nil
+	}
+
+	return c.enc, err
+}
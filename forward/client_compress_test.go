@@ -0,0 +1,152 @@
+package forward
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/webmafia/fast/buffer"
+	"github.com/webmafia/fluentlog/forward/transport"
+	"github.com/webmafia/fluentlog/pkg/msgpack"
+)
+
+func TestClient_Compression(t *testing.T) {
+	for _, comp := range []Compression{CompressGzip, CompressZstd} {
+		t.Run(comp.String(), func(t *testing.T) {
+			msgs := make(chan string, 10)
+			chunks := make(chan transport.Chunk, 10)
+
+			_, addr := testServer(t, ServerOptions{Unauthenticated: true}, func(ctx context.Context, ss *ServerSession) error {
+				ss.OnChunk(func(c *transport.Chunk) error {
+					chunks <- *c
+					return nil
+				})
+
+				return readMessages(ss, msgs)
+			})
+
+			expectChunk := func(entries int) {
+				t.Helper()
+
+				select {
+				case c := <-chunks:
+					if c.Mode != transport.ModeCompressedPackedForward || c.Compression.String() != comp.String() || c.Size != entries || c.Entries != entries {
+						t.Errorf("unexpected %s chunk of %d entries (declared %d) compressed with %s", c.Mode, c.Entries, c.Size, c.Compression)
+					}
+				case <-time.After(5 * time.Second):
+					t.Fatal("timed out waiting for a chunk")
+				}
+			}
+
+			expectMessages := func(expected ...string) {
+				t.Helper()
+
+				for _, msg := range expected {
+					if got := receive(t, msgs); got != msg {
+						t.Errorf("expected %q, got %q", msg, got)
+					}
+				}
+			}
+
+			cli := NewClient(addr, ClientOptions{
+				Unauthenticated: true,
+				Compression:     comp,
+				FlushInterval:   50 * time.Millisecond,
+			})
+
+			defer cli.Close()
+
+			for _, msg := range []string{"a1", "a2", "a3"} {
+				if _, err := cli.Write(testEntry("a", msg)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// A new tag flushes the chunk of the previous one
+			if _, err := cli.Write(testEntry("b", "b1")); err != nil {
+				t.Fatal(err)
+			}
+
+			expectChunk(3)
+			expectMessages("a1", "a2", "a3")
+
+			// The flush interval flushes the rest
+			expectChunk(1)
+			expectMessages("b1")
+
+			// A full chunk is flushed right away
+			small := NewClient(addr, ClientOptions{
+				Unauthenticated: true,
+				Compression:     comp,
+				ChunkSize:       1,
+				FlushInterval:   time.Hour,
+			})
+
+			defer small.Close()
+
+			for _, msg := range []string{"c1", "c2"} {
+				if _, err := small.Write(testEntry("c", msg)); err != nil {
+					t.Fatal(err)
+				}
+
+				expectChunk(1)
+				expectMessages(msg)
+			}
+		})
+	}
+}
+
+func TestClient_CompressionSizeMismatch(t *testing.T) {
+	for _, comp := range []Compression{CompressGzip, CompressZstd} {
+		t.Run(comp.String(), func(t *testing.T) {
+			errs := make(chan error, 10)
+
+			_, addr := testServer(t, ServerOptions{
+				Unauthenticated: true,
+				HandleError:     func(err error) { errs <- err },
+			}, func(ctx context.Context, ss *ServerSession) error {
+				return readMessages(ss, make(chan string, 10))
+			})
+
+			// A chunk of 2 entries, that declares 3
+			enc := NewClient(addr, ClientOptions{Compression: comp})
+			ch := &liveChunk{tag: "test", buf: new(buffer.Buffer)}
+
+			for _, msg := range []string{"a", "b"} {
+				b := testEntry("test", msg)
+				_, ch.strip, _ = messageTag(b)
+				ch.buf.B = append(ch.buf.B, b...)
+				ch.ends = append(ch.ends, ch.buf.Len())
+			}
+
+			frame := new(buffer.Buffer)
+
+			if err := enc.appendChunk(frame, ch, nil); err != nil {
+				t.Fatal(err)
+			}
+
+			// The options come last, after the compressed entries
+			size := msgpack.AppendInt(msgpack.AppendString(nil, "size"), 2)
+			i := bytes.LastIndex(frame.B, size)
+			frame.B = append(frame.B[:i], append(msgpack.AppendInt(msgpack.AppendString(nil, "size"), 3), frame.B[i+len(size):]...)...)
+
+			cli := NewClient(addr, ClientOptions{Unauthenticated: true})
+			defer cli.Close()
+
+			if _, err := cli.Write(frame.B); err != nil {
+				t.Fatal(err)
+			}
+
+			select {
+			case err := <-errs:
+				if !errors.Is(err, transport.ErrSizeMismatch) {
+					t.Errorf("expected ErrSizeMismatch, got %v", err)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("timed out waiting for an error")
+			}
+		})
+	}
+}
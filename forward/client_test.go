@@ -0,0 +1,120 @@
+package forward
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestClient_ConcurrentWrite(t *testing.T) {
+	const (
+		writers = 8
+		writes  = 100
+	)
+
+	msgs := make(chan string, writers*writes)
+
+	_, addr := testServer(t, ServerOptions{Unauthenticated: true}, func(ctx context.Context, ss *ServerSession) error {
+		return readMessages(ss, msgs)
+	})
+
+	cli := NewClient(addr, ClientOptions{Unauthenticated: true})
+	defer cli.Close()
+
+	var wg sync.WaitGroup
+
+	for w := range writers {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for i := range writes {
+				if _, err := cli.Write(testEntry("test", fmt.Sprintf("%d-%d", w, i))); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	// Entries must neither be interleaved nor reordered within each writer
+	var next [writers]int
+
+	for range writers * writes {
+		var w, i int
+
+		if _, err := fmt.Sscanf(receive(t, msgs), "%d-%d", &w, &i); err != nil {
+			t.Fatal(err)
+		}
+
+		if i != next[w] {
+			t.Fatalf("writer %d: expected entry %d, got %d", w, next[w], i)
+		}
+
+		next[w]++
+	}
+}
+
+func TestClient_OnStateChange(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		states []ClientState
+	)
+
+	addr := testAddr(t)
+	cli := NewClient(addr, ClientOptions{
+		Unauthenticated: true,
+		Backoff: Backoff{
+			InitialInterval: 50 * time.Millisecond,
+			NoJitter:        true,
+		},
+		OnStateChange: func(state ClientState, err error) {
+			if (state == StateDown) != (err != nil) {
+				t.Errorf("unexpected error of state %s: %v", state, err)
+			}
+
+			mu.Lock()
+			states = append(states, state)
+			mu.Unlock()
+		},
+	})
+
+	// Nothing is listening yet
+	if _, err := cli.Write(testEntry("test", "a")); err == nil {
+		t.Fatal("expected write to fail")
+	}
+
+	if _, err := cli.Write(testEntry("test", "a")); !errors.Is(err, ErrClientDown) {
+		t.Fatalf("expected ErrClientDown while backing off, got %v", err)
+	}
+
+	testServer(t, ServerOptions{Address: addr, Unauthenticated: true}, func(ctx context.Context, ss *ServerSession) error {
+		return readMessages(ss, make(chan string, 10))
+	})
+
+	time.Sleep(60 * time.Millisecond)
+
+	if _, err := cli.Write(testEntry("test", "a")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cli.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	expected := []ClientState{StateConnecting, StateDown, StateConnecting, StateConnected, StateDisconnected}
+
+	if !slices.Equal(states, expected) {
+		t.Errorf("expected states %v, got %v", expected, states)
+	}
+}
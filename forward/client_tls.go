@@ -0,0 +1,160 @@
+package forward
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+type TLSOptions struct {
+	// Base configuration, e.g. with custom root CAs or client certificates. It's
+	// cloned for every connection.
+	Config *tls.Config
+
+	CAFile   string // PEM bundle of CAs that verifies the server. Defaults to the system roots.
+	CertFile string // PEM client certificate, for mutual TLS.
+	KeyFile  string // PEM private key of the client certificate.
+
+	// Name that the server certificate is verified against. Defaults to the host of
+	// the address.
+	ServerName string
+
+	// Skips verification of the server certificate. Do not use in production.
+	InsecureSkipVerify bool
+}
+
+// Loads TLS configuration for each connection. Any files are reloaded when they have
+// changed on disk, so that rotated certificates are picked up on next connection.
+type tlsLoader struct {
+	opt    *TLSOptions
+	ca     watchedFile
+	cert   watchedFile
+	key    watchedFile
+	pool   *x509.CertPool
+	client *tls.Certificate
+	mu     sync.Mutex
+}
+
+func newTlsLoader(opt *TLSOptions) *tlsLoader {
+	return &tlsLoader{
+		opt:  opt,
+		ca:   watchedFile{name: opt.CAFile},
+		cert: watchedFile{name: opt.CertFile},
+		key:  watchedFile{name: opt.KeyFile},
+	}
+}
+
+func (l *tlsLoader) config(host string) (cfg *tls.Config, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.opt.Config != nil {
+		cfg = l.opt.Config.Clone()
+	} else {
+		cfg = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+
+	if l.opt.ServerName != "" {
+		cfg.ServerName = l.opt.ServerName
+	} else if cfg.ServerName == "" {
+		cfg.ServerName = host
+	}
+
+	if l.opt.InsecureSkipVerify {
+		cfg.InsecureSkipVerify = true
+	}
+
+	if l.opt.CAFile != "" {
+		if err = l.reloadCA(); err != nil {
+			return
+		}
+
+		cfg.RootCAs = l.pool
+	}
+
+	if l.opt.CertFile != "" || l.opt.KeyFile != "" {
+		if err = l.reloadCert(); err != nil {
+			return
+		}
+
+		cfg.Certificates = []tls.Certificate{*l.client}
+	}
+
+	return
+}
+
+func (l *tlsLoader) reloadCA() (err error) {
+	changed, err := l.ca.changed()
+
+	if err != nil || (!changed && l.pool != nil) {
+		return
+	}
+
+	pem, err := os.ReadFile(l.ca.name)
+
+	if err != nil {
+		return
+	}
+
+	pool := x509.NewCertPool()
+
+	if !pool.AppendCertsFromPEM(pem) {
+		return fmt.Errorf("no certificates found in '%s'", l.ca.name)
+	}
+
+	l.pool = pool
+	return
+}
+
+func (l *tlsLoader) reloadCert() (err error) {
+	if l.opt.CertFile == "" || l.opt.KeyFile == "" {
+		return errors.New("both CertFile and KeyFile must be provided")
+	}
+
+	certChanged, err := l.cert.changed()
+
+	if err != nil {
+		return
+	}
+
+	keyChanged, err := l.key.changed()
+
+	if err != nil || (!certChanged && !keyChanged && l.client != nil) {
+		return
+	}
+
+	cert, err := tls.LoadX509KeyPair(l.opt.CertFile, l.opt.KeyFile)
+
+	if err != nil {
+		return
+	}
+
+	l.client = &cert
+	return
+}
+
+type watchedFile struct {
+	name    string
+	modTime time.Time
+	size    int64
+}
+
+// Whether the file has changed since last call.
+func (f *watchedFile) changed() (ok bool, err error) {
+	fi, err := os.Stat(f.name)
+
+	if err != nil {
+		return
+	}
+
+	if ok = !fi.ModTime().Equal(f.modTime) || fi.Size() != f.size; ok {
+		f.modTime = fi.ModTime()
+		f.size = fi.Size()
+	}
+
+	return
+}
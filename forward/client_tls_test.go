@@ -0,0 +1,251 @@
+package forward
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+// A certificate authority that is generated for a test.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testCA{
+		cert: cert,
+		key:  key,
+		pem:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	}
+}
+
+// Issues a certificate for localhost, that is usable by both servers and clients.
+func (ca *testCA) issue(t *testing.T, cn string) (certPEM, keyPEM []byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	return
+}
+
+// Returns a server config with a certificate for localhost.
+func (ca *testCA) serverTls(t *testing.T) *tls.Config {
+	t.Helper()
+
+	cert, err := tls.X509KeyPair(ca.issue(t, "server"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &tls.Config{Certificates: []tls.Certificate{cert}}
+}
+
+func writeTestFile(t *testing.T, name string, data []byte) {
+	t.Helper()
+
+	if err := os.WriteFile(name, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClient_Tls(t *testing.T) {
+	ca := newTestCA(t)
+	caFile := path.Join(t.TempDir(), "ca.pem")
+	writeTestFile(t, caFile, ca.pem)
+
+	msgs := make(chan string, 10)
+
+	_, addr := testServer(t, ServerOptions{Unauthenticated: true, Tls: ca.serverTls(t)}, func(ctx context.Context, ss *ServerSession) error {
+		return readMessages(ss, msgs)
+	})
+
+	tests := []struct {
+		name string
+		opt  TLSOptions
+		ok   bool
+	}{
+		{"CAFile", TLSOptions{CAFile: caFile, ServerName: "localhost"}, true},
+		{"SystemRoots", TLSOptions{ServerName: "localhost"}, false},
+		{"ServerName", TLSOptions{CAFile: caFile, ServerName: "other"}, false},
+		{"InsecureSkipVerify", TLSOptions{ServerName: "other", InsecureSkipVerify: true}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cli := NewClient(addr, ClientOptions{Unauthenticated: true, TLSOptions: &tt.opt})
+			defer cli.Close()
+
+			err := cli.Connect(context.Background())
+
+			if !tt.ok {
+				if err == nil {
+					t.Fatal("expected the server certificate to fail verification")
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err = cli.Write(testEntry("test", tt.name)); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := receive(t, msgs); got != tt.name {
+				t.Errorf("expected %q, got %q", tt.name, got)
+			}
+		})
+	}
+}
+
+func TestClient_TlsClientCertReload(t *testing.T) {
+	var (
+		ca       = newTestCA(t)
+		dir      = t.TempDir()
+		caFile   = path.Join(dir, "ca.pem")
+		certFile = path.Join(dir, "cert.pem")
+		keyFile  = path.Join(dir, "key.pem")
+		names    = make(chan string, 10)
+	)
+
+	writeTestFile(t, caFile, ca.pem)
+
+	rotate := func(cn string) {
+		cert, key := ca.issue(t, cn)
+		writeTestFile(t, certFile, cert)
+		writeTestFile(t, keyFile, key)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	conf := ca.serverTls(t)
+	conf.ClientCAs = roots
+	conf.ClientAuth = tls.RequireAndVerifyClientCert
+
+	// Reports the common name of each verified client certificate
+	conf.VerifyPeerCertificate = func(_ [][]byte, chains [][]*x509.Certificate) error {
+		names <- chains[0][0].Subject.CommonName
+		return nil
+	}
+
+	_, addr := testServer(t, ServerOptions{Unauthenticated: true, Tls: conf}, func(ctx context.Context, ss *ServerSession) error {
+		return readMessages(ss, make(chan string, 10))
+	})
+
+	rotate("first")
+
+	cli := NewClient(addr, ClientOptions{
+		Unauthenticated: true,
+		TLSOptions: &TLSOptions{
+			CAFile:     caFile,
+			CertFile:   certFile,
+			KeyFile:    keyFile,
+			ServerName: "localhost",
+		},
+	})
+
+	defer cli.Close()
+
+	if _, err := cli.Write(testEntry("test", "a")); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := receive(t, names); got != "first" {
+		t.Fatalf("expected the first certificate, got %q", got)
+	}
+
+	// The rotated certificate is picked up on the next connection
+	rotate("second-client")
+
+	if err := cli.Reconnect(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := cli.Write(testEntry("test", "b")); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := receive(t, names); got != "second-client" {
+		t.Fatalf("expected the rotated certificate, got %q", got)
+	}
+}
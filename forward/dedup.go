@@ -0,0 +1,198 @@
+package forward
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/webmafia/fluentlog/forward/transport"
+	"github.com/webmafia/fluentlog/pkg/msgpack"
+)
+
+type DedupOptions struct {
+	Window  time.Duration // Min time that an entry is remembered (see MaxKeys). Defaults to 10 minutes.
+	MaxKeys int           // Max number of remembered entries. Defaults to 1 million.
+
+	// Also deduplicate entries without an "@id" (i.e. of other senders than fluentlog), by a
+	// hash of their tag, timestamp and record.
+	HashForeign bool
+}
+
+func (opt *DedupOptions) setDefaults() {
+	if opt.Window <= 0 {
+		opt.Window = 10 * time.Minute
+	}
+
+	if opt.MaxKeys <= 0 {
+		opt.MaxKeys = 1_000_000
+	}
+}
+
+type DedupStats struct {
+	Checked    uint64 // Number of checked entries
+	Duplicates uint64 // Number of duplicates found
+	Keys       int    // Number of currently remembered entries
+
+	// Number of rotations forced by MaxKeys, before the window had passed. Entries might be
+	// forgotten within the window once this increases, in which case MaxKeys is too low.
+	EarlyRotations uint64
+}
+
+// Returns the share of checked entries that were duplicates, between 0 and 1.
+func (s DedupStats) HitRate() float64 {
+	if s.Checked == 0 {
+		return 0
+	}
+
+	return float64(s.Duplicates) / float64(s.Checked)
+}
+
+// A dedup filter drops entries that have already been seen, e.g. due to retransmission or
+// fallback replay, by their "@id". Seen entries are kept in two generations of hash sets, that
+// are rotated every window - so an entry is remembered for at least the window. To bound the
+// memory, they are also rotated once the current one is half full, which is reported by
+// DedupStats.EarlyRotations. Safe for concurrent use.
+type Dedup struct {
+	opt        DedupOptions
+	mu         sync.Mutex
+	cur, prev  map[uint64]struct{}
+	rotatedAt  time.Time
+	now        func() time.Time // Replaced in tests
+	checked    atomic.Uint64
+	duplicates atomic.Uint64
+	early      atomic.Uint64
+	scratch    sync.Pool
+}
+
+type dedupScratch struct {
+	buf  []byte
+	iter msgpack.Iterator
+}
+
+func NewDedup(options ...DedupOptions) *Dedup {
+	var opt DedupOptions
+
+	if len(options) > 0 {
+		opt = options[0]
+	}
+
+	opt.setDefaults()
+
+	return &Dedup{
+		opt:       opt,
+		cur:       make(map[uint64]struct{}),
+		prev:      make(map[uint64]struct{}),
+		rotatedAt: time.Now(),
+		now:       time.Now,
+		scratch: sync.Pool{
+			New: func() any {
+				return &dedupScratch{iter: msgpack.NewIterator(nil)}
+			},
+		},
+	}
+}
+
+// Reports whether an entry has already been seen, and remembers it otherwise. Entries without
+// "@id" are never duplicates, unless HashForeign is set.
+func (d *Dedup) Check(tag string, ts time.Time, rec msgpack.Value) (dup bool) {
+	key, ok := rec.GetUint("@id")
+
+	if !ok {
+		if !d.opt.HashForeign {
+			return
+		}
+
+		key = hashEntry(tag, ts, rec)
+	}
+
+	d.checked.Add(1)
+
+	if dup = d.seen(key); dup {
+		d.duplicates.Add(1)
+	}
+
+	return
+}
+
+func (d *Dedup) seen(key uint64) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.cur[key]; ok {
+		return true
+	}
+
+	if _, ok := d.prev[key]; ok {
+		return true
+	}
+
+	if d.now().Sub(d.rotatedAt) >= d.opt.Window {
+		d.rotate()
+	} else if len(d.cur) >= d.opt.MaxKeys/2 {
+		d.early.Add(1)
+		d.rotate()
+	}
+
+	d.cur[key] = struct{}{}
+	return false
+}
+
+func (d *Dedup) rotate() {
+	d.prev, d.cur = d.cur, d.prev
+	clear(d.cur)
+	d.rotatedAt = d.now()
+}
+
+func hashEntry(tag string, ts time.Time, rec msgpack.Value) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(tag))
+	h.Write(binary.BigEndian.AppendUint64(nil, uint64(ts.UnixNano())))
+	h.Write(rec)
+	return h.Sum64()
+}
+
+func (d *Dedup) Stats() DedupStats {
+	d.mu.Lock()
+	keys := len(d.cur) + len(d.prev)
+	d.mu.Unlock()
+
+	return DedupStats{
+		Checked:    d.checked.Load(),
+		Duplicates: d.duplicates.Load(),
+		Keys:       keys,
+
+		EarlyRotations: d.early.Load(),
+	}
+}
+
+// Wraps an entry handler, so that duplicate entries are dropped before reaching it.
+func (d *Dedup) Handler(next EntryHandler) EntryHandler {
+	return func(ctx context.Context, ss *ServerSession, e *transport.Entry) (err error) {
+		s := d.scratch.Get().(*dedupScratch)
+		defer d.scratch.Put(s)
+
+		// The record is copied, as it must be read before passing it on
+		if s.buf, err = e.AppendRecord(s.buf[:0]); err != nil {
+			return
+		}
+
+		if d.Check(e.Tag, e.Timestamp, s.buf) {
+			return
+		}
+
+		s.iter.ResetBytes(s.buf)
+
+		if !s.iter.Next() {
+			return errors.Join(ErrInvalidEntry, s.iter.Error())
+		}
+
+		ce := *e
+		ce.Record = &s.iter
+
+		return next(ctx, ss, &ce)
+	}
+}
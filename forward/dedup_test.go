@@ -0,0 +1,89 @@
+package forward
+
+import (
+	"testing"
+	"time"
+
+	"github.com/webmafia/fluentlog/pkg/msgpack"
+)
+
+func TestDedup(t *testing.T) {
+	withId := func(id uint64) msgpack.Value {
+		rec := msgpack.AppendMapHeader(nil, 1)
+		rec = msgpack.AppendString(rec, "@id")
+		return msgpack.AppendUint(rec, id)
+	}
+
+	foreign := msgpack.AppendMapHeader(nil, 1)
+	foreign = msgpack.AppendString(foreign, "log")
+	foreign = msgpack.AppendString(foreign, "hello")
+
+	ts := time.Now()
+	d := NewDedup(DedupOptions{MaxKeys: 4})
+
+	if d.Check("a", ts, withId(1)) {
+		t.Error("first entry reported as duplicate")
+	}
+
+	if !d.Check("a", ts, withId(1)) {
+		t.Error("duplicate not found")
+	}
+
+	if d.Check("a", ts, foreign) || d.Check("a", ts, foreign) {
+		t.Error("foreign entry deduplicated without HashForeign")
+	}
+
+	// Rotates twice, after which the first entry is forgotten
+	for id := uint64(2); id <= 5; id++ {
+		d.Check("a", ts, withId(id))
+	}
+
+	if d.Check("a", ts, withId(1)) {
+		t.Error("entry still remembered after two rotations")
+	}
+
+	if s := d.Stats(); s.Checked != 7 || s.Duplicates != 1 || s.Keys > 4 || s.EarlyRotations != 2 {
+		t.Errorf("unexpected stats: %+v", s)
+	}
+
+	// Entries are remembered for a whole window, and forgotten after two
+	now := ts
+	d = NewDedup(DedupOptions{Window: time.Minute})
+	d.now = func() time.Time { return now }
+	d.rotatedAt = now
+
+	d.Check("a", ts, withId(1))
+	now = now.Add(59 * time.Second)
+
+	if !d.Check("a", ts, withId(1)) {
+		t.Error("entry forgotten within the window")
+	}
+
+	now = now.Add(time.Second)
+	d.Check("a", ts, withId(2))
+
+	if !d.Check("a", ts, withId(1)) {
+		t.Error("entry forgotten after a single rotation")
+	}
+
+	now = now.Add(time.Minute)
+	d.Check("a", ts, withId(3))
+
+	if d.Check("a", ts, withId(1)) {
+		t.Error("entry still remembered after two windows")
+	}
+
+	if s := d.Stats(); s.EarlyRotations != 0 {
+		t.Errorf("unexpected early rotations: %d", s.EarlyRotations)
+	}
+
+	d = NewDedup(DedupOptions{HashForeign: true})
+
+	if d.Check("a", ts, foreign) || !d.Check("a", ts, foreign) {
+		t.Error("foreign entry not deduplicated by hash")
+	}
+
+	if d.Check("b", ts, foreign) {
+		t.Error("entry with another tag reported as duplicate")
+	}
+}
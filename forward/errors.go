@@ -18,4 +18,17 @@ const (
 	ErrFailedConn       = Error("failed connection")
 	ErrFailedAuth       = Error("failed authentication")
 	ErrNotSupported     = Error("not supported")
+	ErrClientDown       = Error("client is down")
+	ErrNoUpstream       = Error("no available upstream")
+	ErrAckTimeout       = Error("acknowledgement timeout")
+	ErrTooManyUnacked   = Error("too many unacknowledged chunks")
+	ErrServerClosed     = Error("server closed")
+	ErrTooManySessions  = Error("too many sessions")
+	ErrIdleTimeout      = Error("idle timeout")
+	ErrChunkRejected    = Error("chunk rejected")
+	ErrUnknownChunk     = Error("unknown chunk")
+	ErrReadTimeout      = Error("read timeout")
+	ErrInvalidSyslog    = Error("invalid syslog message")
+	ErrInvalidProxy     = Error("invalid PROXY header")
+	ErrClientDenied     = Error("client denied")
 )
@@ -0,0 +1,142 @@
+package forward
+
+import (
+	"context"
+	"net"
+	"os"
+	"path"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/webmafia/fluentlog/pkg/msgpack"
+)
+
+// Starts a server on a Unix socket (unless another address is set), that is shut down when
+// the test ends.
+func testServer(t *testing.T, opt ServerOptions, handler func(ctx context.Context, ss *ServerSession) error) (serv *Server, addr string) {
+	t.Helper()
+
+	if opt.Address == "" {
+		opt.Address = testAddr(t)
+	}
+
+	network, sock := splitNetwork(opt.Address)
+	serv = NewServer(opt)
+	done := make(chan error, 1)
+
+	go func() {
+		done <- serv.Listen(context.Background(), handler)
+	}()
+
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		serv.Shutdown(ctx)
+		<-done
+	})
+
+	if network == "unix" {
+		waitFor(t, "server to listen", func() bool {
+			_, err := os.Stat(sock)
+			return err == nil
+		})
+
+		return serv, opt.Address
+	}
+
+	waitFor(t, "server to listen", func() bool {
+		conn, err := net.Dial(network, sock)
+
+		if err != nil {
+			return false
+		}
+
+		conn.Close()
+		return true
+	})
+
+	// The session of the probe must have ended before the test starts
+	waitFor(t, "probe session to end", func() bool {
+		serv.mu.Lock()
+		defer serv.mu.Unlock()
+
+		return atomic.LoadUint64(&serv.sessId) > 0 && serv.active == 0
+	})
+
+	return serv, opt.Address
+}
+
+// Returns the address of a Unix socket in a temporary directory.
+func testAddr(t *testing.T) string {
+	return unixPrefix + path.Join(t.TempDir(), "fluent.sock")
+}
+
+// Returns a free TCP address of localhost.
+func testTcpAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer l.Close()
+
+	return l.Addr().String()
+}
+
+// Returns an entry in Message mode, with a single "message" key.
+func testEntry(tag, message string) []byte {
+	b := msgpack.AppendArrayHeader(nil, 3)
+	b = msgpack.AppendString(b, tag)
+	b = msgpack.AppendTimestamp(b, time.Unix(1700000000, 0))
+	b = msgpack.AppendMapHeader(b, 1)
+	b = msgpack.AppendString(b, "message")
+	return msgpack.AppendString(b, message)
+}
+
+// Reads the "message" key of each entry of a session, and sends it to ch.
+func readMessages(ss *ServerSession, ch chan<- string) error {
+	for e, err := range ss.Entries() {
+		if err != nil {
+			return err
+		}
+
+		o, err := e.Clone()
+
+		if err != nil {
+			return err
+		}
+
+		ch <- o.Record.Get("message").Str()
+	}
+
+	return nil
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func receive(t *testing.T, ch <-chan string) string {
+	t.Helper()
+
+	select {
+	case msg := <-ch:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for an entry")
+		return ""
+	}
+}
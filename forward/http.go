@@ -0,0 +1,209 @@
+package forward
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A HTTP handler that accepts entries like Fluentd's in_http, for producers that can't keep a
+// Forward session (e.g. shell scripts, browsers and serverless functions). Each request to
+// "POST /<tag>" (where any slashes of the path become dots) is passed as a session to the
+// same handlers as Server, and is responded to with 200 OK once the handler has returned
+// without error. The body is either:
+//
+//   - a JSON object or array of objects ("application/json");
+//   - JSON objects separated by newlines ("application/x-ndjson");
+//   - a MessagePack map or array of maps ("application/msgpack");
+//   - a form with a "json" or "msgpack" parameter of the above.
+//
+// The time of each entry is read from a "time" key of its record (which is removed), or
+// else from a "time" parameter of the request, as Unix time in seconds (with any fraction).
+// Entries without either get the current time.
+type HttpHandler struct {
+	serv    *Server
+	opt     HttpOptions
+	handler func(ctx context.Context, ss *ServerSession) error
+	pool    sync.Pool
+}
+
+type HttpOptions struct {
+	// Requests are authenticated with either basic auth (by the username and password), or a
+	// bearer token (by the shared key of an empty username).
+	Auth            AuthServer
+	Unauthenticated bool // Accept requests without credentials, like Fluentd's in_http.
+	HandleError     func(err error)
+	MaxBodySize     int // Max size in bytes of a request body. Defaults to 32 MiB, like Fluentd.
+
+	// Limits of concurrent requests, where zero means unlimited.
+	MaxSessions      int
+	MaxSessionsPerIP int
+}
+
+func (opt *HttpOptions) setDefaults() {
+	if opt.MaxBodySize <= 0 {
+		opt.MaxBodySize = 32 * 1024 * 1024
+	}
+}
+
+func NewHttpHandler(handler func(ctx context.Context, ss *ServerSession) error, options ...HttpOptions) *HttpHandler {
+	var opt HttpOptions
+
+	if len(options) > 0 {
+		opt = options[0]
+	}
+
+	opt.setDefaults()
+
+	h := &HttpHandler{
+		serv: NewServer(ServerOptions{
+			HandleError:      opt.HandleError,
+			Auth:             opt.Auth,
+			Unauthenticated:  opt.Unauthenticated,
+			MaxSessions:      opt.MaxSessions,
+			MaxSessionsPerIP: opt.MaxSessionsPerIP,
+		}),
+		handler: handler,
+	}
+
+	// With the defaults of the server
+	opt.Auth, opt.HandleError = h.serv.opt.Auth, h.serv.opt.HandleError
+	h.opt = opt
+	h.pool.New = func() any {
+		return newHttpEntries()
+	}
+
+	return h
+}
+
+// ServeHTTP implements http.Handler.
+func (h *HttpHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	var username string
+
+	if !h.opt.Unauthenticated {
+		var err error
+
+		if username, err = h.authenticate(r); err != nil {
+			h.opt.HandleError(err)
+			w.Header().Set("WWW-Authenticate", `Basic realm="fluentlog"`)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+	}
+
+	src := h.pool.Get().(*httpEntries)
+	defer h.pool.Put(src)
+
+	r.Body = http.MaxBytesReader(w, r.Body, int64(h.opt.MaxBodySize))
+
+	if err := src.parse(r); err != nil {
+		h.opt.HandleError(err)
+		status := http.StatusBadRequest
+
+		var maxErr *http.MaxBytesError
+
+		if errors.As(err, &maxErr) {
+			status = http.StatusRequestEntityTooLarge
+		}
+
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	conn := newHttpConn(r)
+	ip, err := h.serv.acquireSession(conn)
+
+	if err != nil {
+		h.opt.HandleError(err)
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		return
+	}
+
+	err = h.serv.serve(r.Context(), conn, ip, src, h.handler, func(_ context.Context, ss *ServerSession) error {
+		ss.user = append(ss.user[:0], username...)
+		return nil
+	})
+
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// Authenticates a request, and returns its username.
+func (h *HttpHandler) authenticate(r *http.Request) (username string, err error) {
+	if username, password, ok := r.BasicAuth(); ok {
+		cred, err := h.opt.Auth(r.Context(), username)
+
+		if err != nil {
+			return "", errors.Join(ErrFailedAuth, err)
+		}
+
+		if !equalSecret(password, cred.Password) {
+			return "", ErrFailedAuth
+		}
+
+		return username, nil
+	}
+
+	if sharedKey, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		cred, err := h.opt.Auth(r.Context(), "")
+
+		if err != nil {
+			return "", errors.Join(ErrFailedAuth, err)
+		}
+
+		if !equalSecret(sharedKey, cred.SharedKey) {
+			return "", ErrInvalidSharedKey
+		}
+
+		return "", nil
+	}
+
+	return "", ErrFailedAuth
+}
+
+// Compares a secret in constant time, where an empty secret never matches.
+func equalSecret(given, secret string) bool {
+	return secret != "" && subtle.ConstantTimeCompare([]byte(given), []byte(secret)) == 1
+}
+
+// A request as the connection of a session, of which only the addresses are used, as the
+// body has already been read.
+type httpConn struct {
+	local, remote net.Addr
+}
+
+func newHttpConn(r *http.Request) *httpConn {
+	c := new(httpConn)
+	c.local, _ = r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+
+	if addr, err := netip.ParseAddrPort(r.RemoteAddr); err == nil {
+		c.remote = net.TCPAddrFromAddrPort(addr)
+	}
+
+	return c
+}
+
+func (c *httpConn) Read(_ []byte) (int, error)         { return 0, net.ErrClosed }
+func (c *httpConn) Write(_ []byte) (int, error)        { return 0, ErrNotSupported }
+func (c *httpConn) Close() error                       { return nil }
+func (c *httpConn) LocalAddr() net.Addr                { return c.local }
+func (c *httpConn) RemoteAddr() net.Addr               { return c.remote }
+func (c *httpConn) SetDeadline(_ time.Time) error      { return nil }
+func (c *httpConn) SetReadDeadline(_ time.Time) error  { return nil }
+func (c *httpConn) SetWriteDeadline(_ time.Time) error { return nil }
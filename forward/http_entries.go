@@ -0,0 +1,398 @@
+package forward
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/segmentio/encoding/json"
+	"github.com/webmafia/fast"
+	"github.com/webmafia/fluentlog/forward/transport"
+	"github.com/webmafia/fluentlog/pkg/msgpack"
+	"github.com/webmafia/fluentlog/pkg/msgpack/types"
+)
+
+// The entries of a HTTP request, that are all parsed before being handled.
+type httpEntries struct {
+	tag     string
+	body    []byte
+	buf     []byte // Body converted to MessagePack
+	recs    []byte
+	entries []httpEntry
+	tok     json.Tokenizer
+	iter    msgpack.Iterator
+	next    int
+}
+
+type httpEntry struct {
+	ts       time.Time
+	from, to int // Record as recs[from:to]
+}
+
+func newHttpEntries() *httpEntries {
+	return &httpEntries{
+		iter: msgpack.NewIterator(nil),
+	}
+}
+
+// Parses the tag, time and body of a request.
+func (h *httpEntries) parse(r *http.Request) (err error) {
+	h.tag = strings.ReplaceAll(strings.Trim(r.URL.Path, "/"), "/", ".")
+	h.recs = h.recs[:0]
+	h.entries = h.entries[:0]
+	h.next = 0
+
+	if h.tag == "" {
+		return fmt.Errorf("%w: missing tag", ErrInvalidEntry)
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	switch mediaType {
+
+	case "application/json", "application/x-ndjson", "application/msgpack", "application/x-msgpack":
+		if err = h.readBody(r.Body); err != nil {
+			return
+		}
+
+	default:
+		if err = r.ParseForm(); err != nil {
+			return
+		}
+
+		if v := r.PostForm.Get("json"); v != "" {
+			mediaType = "application/json"
+			h.body = append(h.body[:0], v...)
+		} else if v := r.PostForm.Get("msgpack"); v != "" {
+			mediaType = "application/msgpack"
+			h.body = append(h.body[:0], v...)
+		} else {
+			return fmt.Errorf("%w: expected a json or msgpack parameter", ErrInvalidEntry)
+		}
+
+	}
+
+	ts := time.Now()
+
+	// The form, if any, has been parsed along with the query
+	if v := r.FormValue("time"); v != "" {
+		if ts, err = parseUnixTime(v); err != nil {
+			return
+		}
+	}
+
+	switch mediaType {
+
+	case "application/x-ndjson":
+		for line := range bytes.Lines(h.body) {
+			if line = bytes.TrimSpace(line); len(line) > 0 {
+				if err = h.addJson(line, ts); err != nil {
+					return
+				}
+			}
+		}
+
+	case "application/json":
+		err = h.addJson(h.body, ts)
+
+	default:
+		err = h.addMsgpack(h.body, ts)
+
+	}
+
+	return
+}
+
+func (h *httpEntries) readBody(r io.Reader) (err error) {
+	buf := bytes.NewBuffer(h.body[:0])
+	_, err = buf.ReadFrom(r)
+	h.body = buf.Bytes()
+	return
+}
+
+func (h *httpEntries) addJson(b []byte, ts time.Time) (err error) {
+	h.tok.Reset(b)
+
+	if !h.tok.Next() {
+		return jsonError(&h.tok)
+	}
+
+	if h.buf, err = appendJsonValue(h.buf[:0], &h.tok); err != nil {
+		return
+	}
+
+	if h.tok.Next() {
+		return fmt.Errorf("%w: unexpected %s after JSON value", ErrInvalidEntry, h.tok.Value)
+	}
+
+	if h.tok.Err != nil {
+		return errors.Join(ErrInvalidEntry, h.tok.Err)
+	}
+
+	return h.addValue(h.buf, ts)
+}
+
+func (h *httpEntries) addMsgpack(b []byte, ts time.Time) (err error) {
+	h.iter.ResetBytes(b)
+
+	if !h.iter.Next() {
+		return errors.Join(ErrInvalidEntry, h.iter.Error())
+	}
+
+	// Validates the value before it's read
+	if h.buf, err = h.iter.AppendValue(h.buf[:0]); err != nil {
+		return errors.Join(ErrInvalidEntry, err)
+	}
+
+	return h.addValue(h.buf, ts)
+}
+
+// Adds a record, or an array of records.
+func (h *httpEntries) addValue(v msgpack.Value, ts time.Time) (err error) {
+	switch v.Type() {
+
+	case types.Map:
+		return h.addRecord(v, ts)
+
+	case types.Array:
+		for rec := range v.Array() {
+			if rec.Type() != types.Map {
+				return fmt.Errorf("%w: expected map, got %s", ErrInvalidEntry, rec.Type())
+			}
+
+			if err = h.addRecord(rec, ts); err != nil {
+				return
+			}
+		}
+
+	default:
+		return fmt.Errorf("%w: expected map or array, got %s", ErrInvalidEntry, v.Type())
+
+	}
+
+	return
+}
+
+// Adds a record, with any "time" key as its time.
+func (h *httpEntries) addRecord(rec msgpack.Value, ts time.Time) (err error) {
+	from := len(h.recs)
+	t := rec.Get("time")
+
+	if t.IsZero() {
+		h.recs = append(h.recs, rec...)
+	} else {
+		if ts, err = valueTime(t); err != nil {
+			return
+		}
+
+		n := 0
+
+		for k := range rec.Map() {
+			if !isTimeKey(k) {
+				n++
+			}
+		}
+
+		h.recs = msgpack.AppendMapHeader(h.recs, n)
+
+		for k, v := range rec.Map() {
+			if !isTimeKey(k) {
+				h.recs = append(h.recs, k...)
+				h.recs = append(h.recs, v...)
+			}
+		}
+	}
+
+	h.entries = append(h.entries, httpEntry{ts: ts, from: from, to: len(h.recs)})
+	return
+}
+
+func isTimeKey(k msgpack.Value) bool {
+	return k.Type() == types.Str && k.Str() == "time"
+}
+
+// Reads a time as Unix time in seconds, or as a MessagePack timestamp.
+func valueTime(v msgpack.Value) (t time.Time, err error) {
+	switch v.Type() {
+
+	case types.Int, types.Uint:
+		return time.Unix(v.Int(), 0), nil
+
+	case types.Float:
+		return floatTime(v.Float()), nil
+
+	case types.Str:
+		return parseUnixTime(v.Str())
+
+	case types.Ext:
+		return v.Timestamp(), nil
+
+	}
+
+	return t, fmt.Errorf("%w: invalid time of type %s", ErrInvalidEntry, v.Type())
+}
+
+func parseUnixTime(s string) (t time.Time, err error) {
+	f, err := strconv.ParseFloat(s, 64)
+
+	if err != nil {
+		return t, fmt.Errorf("%w: invalid time '%s'", ErrInvalidEntry, s)
+	}
+
+	return floatTime(f), nil
+}
+
+func floatTime(f float64) time.Time {
+	sec, frac := math.Modf(f)
+	return time.Unix(int64(sec), int64(frac*1e9))
+}
+
+// Next implements entrySource.
+func (h *httpEntries) Next(e *transport.Entry) error {
+	if h.next >= len(h.entries) {
+		return io.EOF
+	}
+
+	*e = transport.Entry{
+		Tag:       h.tag,
+		Timestamp: h.entries[h.next].ts,
+		Record:    &h.iter,
+		Index:     h.next,
+	}
+
+	h.next++
+	h.Rewind()
+	return nil
+}
+
+// Rewind implements entrySource.
+func (h *httpEntries) Rewind() {
+	if h.next > 0 {
+		ent := h.entries[h.next-1]
+		h.iter.ResetBytes(h.recs[ent.from:ent.to])
+		h.iter.Next()
+	}
+}
+
+// Idle implements entrySource. As the request has already been read, the session never
+// blocks.
+func (h *httpEntries) Idle() bool {
+	return false
+}
+
+// Converts the JSON value of the current token to MessagePack. Maps and arrays get 32-bit
+// headers, that are filled in once their number of items is known.
+func appendJsonValue(dst []byte, tok *json.Tokenizer) (_ []byte, err error) {
+	switch tok.Delim {
+
+	case 0:
+		switch tok.Kind() {
+		case json.Null:
+			dst = msgpack.AppendNil(dst)
+		case json.True, json.False:
+			dst = msgpack.AppendBool(dst, tok.Bool())
+		case json.Uint:
+			dst = msgpack.AppendUint(dst, tok.Uint())
+		case json.Int:
+			dst = msgpack.AppendInt(dst, tok.Int())
+		case json.Float:
+			dst = msgpack.AppendFloat(dst, tok.Float())
+		default:
+			dst = msgpack.AppendString(dst, fast.BytesToString(tok.String()))
+		}
+
+	case '[':
+		head := len(dst)
+		dst = append(dst, 0xdd, 0, 0, 0, 0)
+		n := 0
+
+		for {
+			if !tok.Next() {
+				return dst, jsonError(tok)
+			}
+
+			if tok.Delim == ']' {
+				break
+			}
+
+			if n > 0 {
+				if tok.Delim != ',' || !tok.Next() {
+					return dst, jsonError(tok)
+				}
+			}
+
+			if dst, err = appendJsonValue(dst, tok); err != nil {
+				return
+			}
+
+			n++
+		}
+
+		binary.BigEndian.PutUint32(dst[head+1:], uint32(n))
+
+	case '{':
+		head := len(dst)
+		dst = append(dst, 0xdf, 0, 0, 0, 0)
+		n := 0
+
+		for {
+			if !tok.Next() {
+				return dst, jsonError(tok)
+			}
+
+			if tok.Delim == '}' {
+				break
+			}
+
+			if n > 0 {
+				if tok.Delim != ',' || !tok.Next() {
+					return dst, jsonError(tok)
+				}
+			}
+
+			if !tok.IsKey || tok.Kind().Class() != json.String {
+				return dst, jsonError(tok)
+			}
+
+			dst = msgpack.AppendString(dst, fast.BytesToString(tok.String()))
+
+			if !tok.Next() || tok.Delim != ':' || !tok.Next() {
+				return dst, jsonError(tok)
+			}
+
+			if dst, err = appendJsonValue(dst, tok); err != nil {
+				return
+			}
+
+			n++
+		}
+
+		binary.BigEndian.PutUint32(dst[head+1:], uint32(n))
+
+	default:
+		return dst, jsonError(tok)
+
+	}
+
+	return dst, nil
+}
+
+func jsonError(tok *json.Tokenizer) error {
+	if tok.Err != nil {
+		return errors.Join(ErrInvalidEntry, tok.Err)
+	}
+
+	if len(tok.Value) == 0 {
+		return fmt.Errorf("%w: unexpected end of JSON", ErrInvalidEntry)
+	}
+
+	return fmt.Errorf("%w: unexpected %s in JSON", ErrInvalidEntry, tok.Value)
+}
@@ -0,0 +1,94 @@
+package forward
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/webmafia/fluentlog/forward/transport"
+	"github.com/webmafia/fluentlog/pkg/msgpack"
+)
+
+func TestHttpHandler(t *testing.T) {
+	var got []*transport.OwnedEntry
+
+	h := NewHttpHandler(func(ctx context.Context, ss *ServerSession) error {
+		for e, err := range ss.Entries() {
+			if err != nil {
+				return err
+			}
+
+			o, err := e.Clone()
+
+			if err != nil {
+				return err
+			}
+
+			got = append(got, o)
+		}
+
+		return nil
+	}, HttpOptions{
+		Auth: StaticAuthServer(Credentials{SharedKey: "secret"}),
+	})
+
+	post := func(path, contentType, body, auth string) int {
+		r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		r.Header.Set("Content-Type", contentType)
+		r.Header.Set("Authorization", auth)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	form := url.Values{"json": {`{"message":"from form"}`}}.Encode()
+	rec := msgpack.AppendMapHeader(nil, 2)
+	rec = msgpack.AppendString(rec, "message")
+	rec = msgpack.AppendString(rec, "from msgpack")
+	rec = msgpack.AppendString(rec, "time")
+	rec = msgpack.AppendTimestamp(rec, time.Unix(1700000002, 0))
+
+	tests := []struct {
+		path, contentType, body, auth string
+		status                        int
+		entries                       int
+	}{
+		{"/app/web", "application/json", `[{"message":"a","time":1700000000.5},{"message":"b","nested":{"x":[1,-2,3.5,null,true]}}]`, "Bearer secret", 200, 2},
+		{"/app.web?time=1700000001", "application/x-ndjson", "{\"message\":\"c\"}\n\n{\"message\":\"d\",\"time\":\"1700000000\"}\n", "Bearer secret", 200, 2},
+		{"/app.web", "application/msgpack", string(rec), "Bearer secret", 200, 1},
+		{"/app.web", "application/x-www-form-urlencoded", form, "Bearer secret", 200, 1},
+		{"/app.web", "application/json", `{"message":"e"}`, "Bearer wrong", 401, 0},
+		{"/app.web", "application/json", `{"message":"e"}`, "", 401, 0},
+		{"/app.web", "application/json", `{"message":"e",}`, "Bearer secret", 400, 0},
+		{"/app.web", "application/json", `{"message":"e"} {}`, "Bearer secret", 400, 0},
+		{"/app.web", "application/json", `[{"message":"e"},1]`, "Bearer secret", 400, 0},
+		{"/", "application/json", `{"message":"e"}`, "Bearer secret", 400, 0},
+	}
+
+	for _, tt := range tests {
+		got = got[:0]
+
+		if status := post(tt.path, tt.contentType, tt.body, tt.auth); status != tt.status || len(got) != tt.entries {
+			t.Errorf("%s %q: got status %d and %d entries, expected %d and %d", tt.path, tt.body, status, len(got), tt.status, tt.entries)
+		}
+	}
+
+	got = got[:0]
+	post("/app/web?time=1700000001", "application/json", `[{"message":"a","time":1700000000.5},{"message":"b","nested":{"x":[1,-2,3.5,null,true]}}]`, "Bearer secret")
+
+	if len(got) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(got))
+	}
+
+	if e := got[0]; e.Tag != "app.web" || !e.Timestamp.Equal(time.Unix(1700000000, 5e8)) || !e.Record.Get("time").IsZero() || e.Record.Len() != 1 {
+		t.Errorf("unexpected entry: %s %s %s", e.Tag, e.Timestamp, e.Record)
+	}
+
+	if e := got[1]; !e.Timestamp.Equal(time.Unix(1700000001, 0)) || e.Record.Get("nested", "x").Len() != 5 {
+		t.Errorf("unexpected entry: %s %s", e.Timestamp, e.Record)
+	}
+}
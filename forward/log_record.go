@@ -0,0 +1,127 @@
+package forward
+
+import (
+	"iter"
+	"strings"
+	"time"
+
+	"github.com/webmafia/fluentlog"
+	"github.com/webmafia/fluentlog/forward/transport"
+	"github.com/webmafia/fluentlog/pkg/msgpack"
+	"github.com/webmafia/fluentlog/pkg/msgpack/types"
+	"github.com/webmafia/hexid"
+)
+
+// Keys that other senders commonly use for the message and severity, in order of precedence.
+var (
+	messageKeys  = [...]string{"message", "log", "msg"}
+	severityKeys = [...]string{"pri", "severity", "level"}
+)
+
+// A record as written by a fluentlog.Instance, decoded into its well-known fields. Records of
+// other senders are decoded as well, with the message and severity read from common keys. All
+// strings refer to Record, and are only valid until the LogRecord is decoded into again.
+type LogRecord struct {
+	Tag        string
+	Time       time.Time
+	ID         hexid.ID           // Value of "@id", if any
+	Severity   fluentlog.Severity // Defaults to INFO if not found
+	Message    string
+	StackTrace []string // Frames as "file:line"
+
+	// Whether the record was written by a fluentlog.Instance, i.e. has both "@id" and "pri".
+	Fluentlog bool
+
+	Record msgpack.Value // The whole record
+	buf    []byte
+	msgKey string
+	sevKey string
+}
+
+// Decodes an entry, whose record is copied and consumed.
+func (r *LogRecord) Decode(e *transport.Entry) (err error) {
+	if r.buf, err = e.AppendRecord(r.buf[:0]); err != nil {
+		return
+	}
+
+	r.decode(e.Tag, e.Timestamp, r.buf)
+	return
+}
+
+// Decodes an owned entry, whose record is referred to rather than copied.
+func (r *LogRecord) DecodeOwned(o *transport.OwnedEntry) {
+	r.decode(o.Tag, o.Timestamp, o.Record)
+}
+
+func (r *LogRecord) decode(tag string, ts time.Time, rec msgpack.Value) {
+	*r = LogRecord{
+		Tag:        strings.Clone(tag),
+		Time:       ts,
+		Severity:   fluentlog.INFO,
+		StackTrace: r.StackTrace[:0],
+		Record:     rec,
+		buf:        r.buf,
+	}
+
+	id, hasId := rec.GetUint("@id")
+	r.ID = hexid.ID(id)
+
+	for _, key := range messageKeys {
+		if msg, ok := rec.GetStr(key); ok {
+			r.Message, r.msgKey = msg, key
+			break
+		}
+	}
+
+	for _, key := range severityKeys {
+		if sev, ok := parseSeverity(rec.Get(key)); ok {
+			r.Severity, r.sevKey = sev, key
+			break
+		}
+	}
+
+	for frame := range rec.Get("stackTrace").Array() {
+		if frame.Type() == types.Str {
+			r.StackTrace = append(r.StackTrace, frame.Str())
+		}
+	}
+
+	r.Fluentlog = hasId && r.sevKey == "pri"
+}
+
+// Reads a severity from its number or name.
+func parseSeverity(v msgpack.Value) (sev fluentlog.Severity, ok bool) {
+	switch v.Type() {
+
+	case types.Int, types.Uint:
+		if n := v.Int(); n >= 0 && n <= int64(fluentlog.DEBUG) {
+			return fluentlog.Severity(n), true
+		}
+
+	case types.Str:
+		if sev, err := fluentlog.ParseSeverity(v.Str()); err == nil {
+			return sev, true
+		}
+
+	}
+
+	return
+}
+
+// Iterates the remaining fields of the record, i.e. all but the decoded ones.
+func (r *LogRecord) Fields() iter.Seq2[string, msgpack.Value] {
+	return func(yield func(string, msgpack.Value) bool) {
+		for k, v := range r.Record.Map() {
+			key := k.Str()
+
+			switch key {
+			case "@id", "stackTrace", r.msgKey, r.sevKey:
+				continue
+			}
+
+			if !yield(key, v) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package forward
+
+import (
+	"testing"
+	"time"
+
+	"github.com/webmafia/fluentlog"
+	"github.com/webmafia/fluentlog/forward/transport"
+	"github.com/webmafia/fluentlog/pkg/msgpack"
+	"github.com/webmafia/hexid"
+)
+
+func TestLogRecord(t *testing.T) {
+	id := hexid.Generate()
+
+	var rec msgpack.Value
+	rec = msgpack.AppendMapHeader(rec, 5)
+	rec = msgpack.AppendString(rec, "@id")
+	rec = msgpack.AppendUint(rec, id.Uint64())
+	rec = msgpack.AppendString(rec, "pri")
+	rec = msgpack.AppendUint(rec, uint64(fluentlog.ERR))
+	rec = msgpack.AppendString(rec, "message")
+	rec = msgpack.AppendString(rec, "failed")
+	rec = msgpack.AppendString(rec, "user")
+	rec = msgpack.AppendString(rec, "alice")
+	rec = msgpack.AppendString(rec, "stackTrace")
+	rec = msgpack.AppendArrayHeader(rec, 2)
+	rec = msgpack.AppendString(rec, "main.go:12")
+	rec = msgpack.AppendString(rec, "proc.go:283")
+
+	var r LogRecord
+	r.DecodeOwned(&transport.OwnedEntry{Tag: "app", Timestamp: id.Time(), Record: rec})
+
+	if !r.Fluentlog || r.ID != id || r.Severity != fluentlog.ERR || r.Message != "failed" || r.Tag != "app" {
+		t.Errorf("unexpected record: %+v", r)
+	}
+
+	if len(r.StackTrace) != 2 || r.StackTrace[0] != "main.go:12" {
+		t.Errorf("unexpected stack trace: %v", r.StackTrace)
+	}
+
+	fields := map[string]string{}
+
+	for k, v := range r.Fields() {
+		fields[k] = v.String()
+	}
+
+	if len(fields) != 1 || fields["user"] != "alice" {
+		t.Errorf("unexpected fields: %v", fields)
+	}
+
+	// E.g. Fluent Bit tailing a file
+	rec = msgpack.AppendMapHeader(rec[:0], 2)
+	rec = msgpack.AppendString(rec, "log")
+	rec = msgpack.AppendString(rec, "hello")
+	rec = msgpack.AppendString(rec, "level")
+	rec = msgpack.AppendString(rec, "Warning")
+
+	r.DecodeOwned(&transport.OwnedEntry{Tag: "tail", Timestamp: time.Now(), Record: rec})
+
+	if r.Fluentlog || !r.ID.IsZero() || r.Severity != fluentlog.WARN || r.Message != "hello" || len(r.StackTrace) != 0 {
+		t.Errorf("unexpected foreign record: %+v", r)
+	}
+
+	for k := range r.Fields() {
+		t.Errorf("unexpected field: %s", k)
+	}
+}
@@ -0,0 +1,50 @@
+package forward
+
+import (
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+const unixPrefix = "unix://"
+
+// Splits an address into network and address, where "unix:///path/to/socket" is a
+// Unix domain socket and anything else is a TCP address.
+func splitNetwork(addr string) (network, address string) {
+	if path, ok := strings.CutPrefix(addr, unixPrefix); ok {
+		return "unix", path
+	}
+
+	return "tcp", addr
+}
+
+func isUnix(addr string) bool {
+	return strings.HasPrefix(addr, unixPrefix)
+}
+
+// Removes a stale Unix socket file left behind by a server that didn't shut down
+// cleanly. A socket that is still accepting connections is left untouched.
+func removeStaleSocket(path string) (err error) {
+	fi, err := os.Lstat(path)
+
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+
+		return
+	}
+
+	if fi.Mode()&os.ModeSocket == 0 {
+		return errors.New("not a socket: " + path)
+	}
+
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		conn.Close()
+		return errors.New("socket already in use: " + path)
+	}
+
+	return os.Remove(path)
+}
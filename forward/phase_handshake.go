@@ -179,6 +179,10 @@ func (ss *ServerSession) readPing(ctx context.Context, nonce, auth string) (salt
 		return
 	}
 
+	if ss.client != nil && ss.client.SharedKey != "" {
+		cred.SharedKey = ss.client.SharedKey
+	}
+
 	// Validate shared key
 	if !validateSha512Hex(hexdigest, salt, clientHostname, nonce, cred.SharedKey) {
 		err = ErrInvalidSharedKey
@@ -0,0 +1,289 @@
+package forward
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const proxyHeaderTimeout = 10 * time.Second
+
+var proxySignature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// A listener whose connections start with a PROXY protocol header (v1 or v2) of a load
+// balancer. Headers are read concurrently, so that a slow (or malicious) peer can't block
+// other connections from being accepted.
+type proxyListener struct {
+	net.Listener
+	conns       chan net.Conn
+	done        chan struct{}
+	err         error
+	handleError func(err error)
+	closeOnce   sync.Once
+}
+
+func newProxyListener(l net.Listener, handleError func(err error)) *proxyListener {
+	pl := &proxyListener{
+		Listener:    l,
+		conns:       make(chan net.Conn),
+		done:        make(chan struct{}),
+		handleError: handleError,
+	}
+
+	go pl.acceptLoop()
+	return pl
+}
+
+func (l *proxyListener) acceptLoop() {
+	for {
+		conn, err := l.Listener.Accept()
+
+		if err != nil {
+			l.err = err
+			close(l.done)
+			return
+		}
+
+		go l.handshake(conn)
+	}
+}
+
+func (l *proxyListener) handshake(conn net.Conn) {
+	pc, err := newProxyConn(conn)
+
+	if err != nil {
+		conn.Close()
+		l.handleError(fmt.Errorf("%w from %s: %w", ErrInvalidProxy, conn.RemoteAddr(), err))
+		return
+	}
+
+	select {
+	case l.conns <- pc:
+	case <-l.done:
+		conn.Close()
+	}
+}
+
+// Accept implements net.Listener.
+func (l *proxyListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, l.err
+	}
+}
+
+// Close implements net.Listener.
+func (l *proxyListener) Close() (err error) {
+	l.closeOnce.Do(func() {
+		err = l.Listener.Close()
+	})
+
+	return
+}
+
+// A connection with the addresses of its PROXY protocol header.
+type proxyConn struct {
+	net.Conn
+	local, remote net.Addr
+	buf           []byte // Read past the header
+}
+
+func newProxyConn(conn net.Conn) (c *proxyConn, err error) {
+	c = &proxyConn{Conn: conn}
+
+	if err = conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout)); err != nil {
+		return
+	}
+
+	r := bufio.NewReaderSize(conn, 256)
+
+	if c.remote, c.local, err = readProxyHeader(r); err != nil {
+		return
+	}
+
+	if err = conn.SetReadDeadline(time.Time{}); err != nil {
+		return
+	}
+
+	if n := r.Buffered(); n > 0 {
+		buf, _ := r.Peek(n)
+		c.buf = bytes.Clone(buf)
+	}
+
+	return
+}
+
+// Read implements net.Conn.
+func (c *proxyConn) Read(b []byte) (n int, err error) {
+	if len(c.buf) > 0 {
+		n = copy(b, c.buf)
+		c.buf = c.buf[n:]
+		return
+	}
+
+	return c.Conn.Read(b)
+}
+
+// LocalAddr implements net.Conn, and returns the destination address of the header.
+func (c *proxyConn) LocalAddr() net.Addr {
+	if c.local != nil {
+		return c.local
+	}
+
+	return c.Conn.LocalAddr()
+}
+
+// RemoteAddr implements net.Conn, and returns the source address of the header.
+func (c *proxyConn) RemoteAddr() net.Addr {
+	if c.remote != nil {
+		return c.remote
+	}
+
+	return c.Conn.RemoteAddr()
+}
+
+// Reads a PROXY protocol header, of either version. Headers without addresses (e.g. of
+// health checks) return nil addresses.
+func readProxyHeader(r *bufio.Reader) (src, dst net.Addr, err error) {
+	sig, err := r.Peek(len(proxySignature))
+
+	if err != nil {
+		return
+	}
+
+	if bytes.Equal(sig, proxySignature) {
+		return readProxyHeaderV2(r)
+	}
+
+	if bytes.HasPrefix(sig, []byte("PROXY ")) {
+		return readProxyHeaderV1(r)
+	}
+
+	return nil, nil, errors.New("missing header")
+}
+
+// Reads a human-readable header, e.g. "PROXY TCP4 192.0.2.1 192.0.2.2 56324 24224\r\n".
+func readProxyHeaderV1(r *bufio.Reader) (src, dst net.Addr, err error) {
+	const maxLen = 107
+
+	var line []byte
+
+	for len(line) < maxLen {
+		b, err := r.ReadByte()
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		line = append(line, b)
+
+		if b == '\n' {
+			break
+		}
+	}
+
+	str, ok := strings.CutSuffix(string(line), "\r\n")
+
+	if !ok {
+		return nil, nil, errors.New("header too long")
+	}
+
+	fields := strings.Split(str, " ")
+
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return
+	}
+
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, nil, fmt.Errorf("invalid header '%s'", str)
+	}
+
+	if src, err = parseProxyAddr(fields[2], fields[4]); err != nil {
+		return
+	}
+
+	dst, err = parseProxyAddr(fields[3], fields[5])
+	return
+}
+
+func parseProxyAddr(ip, port string) (addr net.Addr, err error) {
+	a, err := netip.ParseAddr(ip)
+
+	if err != nil {
+		return
+	}
+
+	p, err := strconv.ParseUint(port, 10, 16)
+
+	if err != nil {
+		return
+	}
+
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(a, uint16(p))), nil
+}
+
+// Reads a binary header, of which any TLVs are ignored.
+func readProxyHeaderV2(r *bufio.Reader) (src, dst net.Addr, err error) {
+	head, err := r.Peek(16)
+
+	if err != nil {
+		return
+	}
+
+	verCmd, family := head[12], head[13]
+	size := int(binary.BigEndian.Uint16(head[14:]))
+
+	if verCmd>>4 != 2 {
+		return nil, nil, fmt.Errorf("unsupported version %d", verCmd>>4)
+	}
+
+	var addrLen int
+
+	switch family >> 4 {
+	case 1:
+		addrLen = 4
+	case 2:
+		addrLen = 16
+	}
+
+	cmd := verCmd & 0x0f
+
+	if cmd > 1 {
+		return nil, nil, fmt.Errorf("unsupported command %d", cmd)
+	}
+
+	// Only TCP over IPv4 or IPv6 of the PROXY command have addresses that are used
+	if cmd == 1 && addrLen > 0 && family&0x0f == 1 {
+		if size < 2*addrLen+4 {
+			return nil, nil, errors.New("truncated addresses")
+		}
+
+		if head, err = r.Peek(16 + 2*addrLen + 4); err != nil {
+			return
+		}
+
+		b := head[16:]
+		srcIP, _ := netip.AddrFromSlice(b[:addrLen])
+		dstIP, _ := netip.AddrFromSlice(b[addrLen : 2*addrLen])
+		ports := b[2*addrLen:]
+
+		src = net.TCPAddrFromAddrPort(netip.AddrPortFrom(srcIP, binary.BigEndian.Uint16(ports)))
+		dst = net.TCPAddrFromAddrPort(netip.AddrPortFrom(dstIP, binary.BigEndian.Uint16(ports[2:])))
+	}
+
+	if _, err = r.Discard(16 + size); err != nil {
+		return nil, nil, err
+	}
+
+	return
+}
@@ -0,0 +1,79 @@
+package forward
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestProxyConn(t *testing.T) {
+	v2 := append([]byte(nil), proxySignature...)
+	v2 = append(v2, 0x21, 0x11)
+	v2 = binary.BigEndian.AppendUint16(v2, 12+3)
+	v2 = append(v2, 203, 0, 113, 7, 10, 0, 0, 1)
+	v2 = binary.BigEndian.AppendUint16(v2, 51000)
+	v2 = binary.BigEndian.AppendUint16(v2, 24224)
+	v2 = append(v2, 0x04, 0, 0) // Empty NOOP TLV
+
+	local := append([]byte(nil), proxySignature...)
+	local = append(local, 0x20, 0x00, 0, 0)
+
+	tests := []struct {
+		header string
+		remote string // Empty for the address of the connection
+		local  string
+		valid  bool
+	}{
+		{"PROXY TCP4 203.0.113.7 10.0.0.1 51000 24224\r\n", "203.0.113.7:51000", "10.0.0.1:24224", true},
+		{"PROXY TCP6 2001:db8::7 2001:db8::1 51000 24224\r\n", "[2001:db8::7]:51000", "[2001:db8::1]:24224", true},
+		{"PROXY UNKNOWN\r\n", "", "", true},
+		{string(v2), "203.0.113.7:51000", "10.0.0.1:24224", true},
+		{string(local), "", "", true},
+		{"PROXY TCP4 203.0.113.7 10.0.0.1 51000\r\n", "", "", false},
+		{"PROXY TCP4 not.an.ip 10.0.0.1 51000 24224\r\n", "", "", false},
+		{"GET / HTTP/1.1\r\n\r\n", "", "", false},
+	}
+
+	for _, tt := range tests {
+		client, server := net.Pipe()
+
+		go func() {
+			client.Write([]byte(tt.header + "payload"))
+			client.Close()
+		}()
+
+		conn, err := newProxyConn(server)
+
+		if !tt.valid {
+			if err == nil {
+				t.Errorf("%q: expected an error", tt.header)
+			}
+
+			server.Close()
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%q: %v", tt.header, err)
+			server.Close()
+			continue
+		}
+
+		remote, local := tt.remote, tt.local
+
+		if remote == "" {
+			remote, local = server.RemoteAddr().String(), server.LocalAddr().String()
+		}
+
+		if conn.RemoteAddr().String() != remote || conn.LocalAddr().String() != local {
+			t.Errorf("%q: got %s -> %s, expected %s -> %s", tt.header, conn.RemoteAddr(), conn.LocalAddr(), remote, local)
+		}
+
+		if b, err := io.ReadAll(conn); err != nil || string(b) != "payload" {
+			t.Errorf("%q: got payload %q, %v", tt.header, b, err)
+		}
+
+		conn.Close()
+	}
+}
@@ -0,0 +1,368 @@
+package forward
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/webmafia/fluentlog/fallback"
+	"github.com/webmafia/fluentlog/forward/transport"
+	"github.com/webmafia/fluentlog/pkg/msgpack"
+)
+
+// Implemented by both Client and UpstreamClient.
+type batchWriter interface {
+	WriteBatch(tag string, size int, r io.Reader) error
+}
+
+type RelayOptions struct {
+	MaxBatchSize  int           // Max uncompressed size of a batch per tag. Defaults to 1 MB.
+	FlushInterval time.Duration // Max time an entry is batched. Defaults to 1 second.
+
+	// Returns the fallback buffer of a tag, to which batches are spilled while the upstream
+	// is down, and from which they are replayed once it's back. Without fallback, the session
+	// fails instead, and the downstream client is expected to retransmit any unacknowledged
+	// chunk.
+	Fallback func(tag string) (fallback.Fallback, error)
+
+	HandleError func(err error)
+}
+
+func (opt *RelayOptions) setDefaults() {
+	if opt.MaxBatchSize <= 0 {
+		opt.MaxBatchSize = 1024 * 1024
+	}
+
+	if opt.FlushInterval <= 0 {
+		opt.FlushInterval = time.Second
+	}
+
+	if opt.HandleError == nil {
+		opt.HandleError = func(err error) {}
+	}
+}
+
+// A relay forwards received entries to an upstream server (a.k.a. aggregator mode), without
+// decoding their records. Entries are batched per tag into gzip-compressed chunks, that are
+// sent with WriteBatch. Downstream chunks are only acknowledged once all entries read so far
+// have been written upstream - with a client that requires acknowledgements, this means
+// acknowledged upstream.
+type Relay struct {
+	cli       batchWriter
+	opt       RelayOptions
+	mu        sync.Mutex // Protects batches
+	batches   map[string]*relayBatch
+	sendMu    sync.Mutex             // Serializes the sending, and protects all below
+	sending   map[string]*relayBatch // Batches being sent, that are swapped with batches
+	fallbacks map[string]*relayFallback
+	gz        *gzip.Writer
+	comp      bytes.Buffer
+	close     chan struct{}
+	wg        sync.WaitGroup
+}
+
+// Entries of a tag, as arrays of 2 items (timestamp + record).
+type relayBatch struct {
+	buf []byte
+	n   int
+}
+
+type relayFallback struct {
+	fb       fallback.Fallback
+	hasData  bool
+	unsynced bool // Whether batches have been spilled since the last sync
+}
+
+func NewRelay(cli batchWriter, options ...RelayOptions) *Relay {
+	var opt RelayOptions
+
+	if len(options) > 0 {
+		opt = options[0]
+	}
+
+	opt.setDefaults()
+
+	r := &Relay{
+		cli:       cli,
+		opt:       opt,
+		batches:   make(map[string]*relayBatch),
+		sending:   make(map[string]*relayBatch),
+		fallbacks: make(map[string]*relayFallback),
+		gz:        gzip.NewWriter(nil),
+		close:     make(chan struct{}),
+	}
+
+	r.wg.Add(1)
+	go r.flushInterval()
+
+	return r
+}
+
+// Serve reads the entries of a session and relays them, until an error occurs. It has the
+// signature of a Server.Listen handler.
+func (r *Relay) Serve(ctx context.Context, ss *ServerSession) (err error) {
+	var (
+		e   transport.Entry
+		rec []byte
+	)
+
+	// Acknowledge a downstream chunk once all its entries have been written upstream, or synced
+	// to their fallback buffers. If that fails, the session fails without acknowledging it.
+	ss.onAck = func(chunk string) (err error) {
+		if err = r.Flush(); err != nil {
+			return
+		}
+
+		return ss.writeAck(chunk)
+	}
+
+	for {
+		if err = ss.Next(&e); err != nil {
+			return
+		}
+
+		// The record is read before locking, as it might need to wait for the connection
+		if rec, err = e.AppendRecord(rec[:0]); err != nil {
+			return
+		}
+
+		if err = r.add(e.Tag, e.Timestamp, rec); err != nil {
+			return
+		}
+	}
+}
+
+func (r *Relay) add(tag string, ts time.Time, rec []byte) (err error) {
+	r.mu.Lock()
+
+	b, ok := r.batches[tag]
+
+	if !ok {
+		b = new(relayBatch)
+		r.batches[tag] = b
+	}
+
+	b.buf = append(b.buf, 0x92)
+	b.buf = msgpack.AppendTimestamp(b.buf, ts, msgpack.TsFluentd)
+	b.buf = append(b.buf, rec...)
+	b.n++
+	full := len(b.buf) >= r.opt.MaxBatchSize
+	r.mu.Unlock()
+
+	// The session is held up until a full batch has been sent
+	if full {
+		err = r.Flush()
+	}
+
+	return
+}
+
+// Flush writes all batched entries upstream, or to their fallback buffers. The batches are
+// swapped out before being sent, so that sessions can go on batching entries meanwhile.
+func (r *Relay) Flush() (err error) {
+	r.sendMu.Lock()
+	defer r.sendMu.Unlock()
+
+	return r.flush()
+}
+
+func (r *Relay) flush() (err error) {
+
+	// Entries in fallback buffers are older, and are replayed first
+	for tag, fb := range r.fallbacks {
+		if fb.hasData {
+			r.replay(tag, fb)
+		}
+	}
+
+	// Any batches that failed to be sent before are older than the batched ones
+	if err = r.flushSending(); err != nil {
+		return
+	}
+
+	r.mu.Lock()
+	r.batches, r.sending = r.sending, r.batches
+	r.mu.Unlock()
+
+	if err = r.flushSending(); err != nil {
+		return
+	}
+
+	// Spilled entries must survive a crash before their chunks are acknowledged
+	for tag, fb := range r.fallbacks {
+		if !fb.unsynced {
+			continue
+		}
+
+		if err = fb.fb.Sync(); err != nil {
+			return fmt.Errorf("sync of fallback for tag %q failed: %w", tag, err)
+		}
+
+		fb.unsynced = false
+	}
+
+	return
+}
+
+func (r *Relay) flushSending() (err error) {
+	for tag, b := range r.sending {
+		if err = r.flushTag(tag, b); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+func (r *Relay) flushTag(tag string, b *relayBatch) (err error) {
+	if b.n == 0 {
+		return
+	}
+
+	fb, err := r.fallback(tag)
+
+	if err != nil {
+		return
+	}
+
+	// Entries in the fallback buffer are older, and must be sent first
+	if fb != nil && fb.hasData {
+		r.replay(tag, fb)
+	}
+
+	if fb == nil || !fb.hasData {
+		if err = r.writeBatch(tag, b); err == nil {
+			b.reset()
+			return
+		}
+
+		if fb == nil {
+			return
+		}
+
+		r.opt.HandleError(fmt.Errorf("relay of %d entries tagged %q failed, spilling to fallback: %w", b.n, tag, err))
+	}
+
+	if _, err = fb.fb.Write(b.buf); err != nil {
+		return
+	}
+
+	fb.hasData = true
+	fb.unsynced = true
+	b.reset()
+	return
+}
+
+func (r *Relay) writeBatch(tag string, b *relayBatch) (err error) {
+	r.comp.Reset()
+	r.gz.Reset(&r.comp)
+
+	if _, err = r.gz.Write(b.buf); err != nil {
+		return
+	}
+
+	if err = r.gz.Close(); err != nil {
+		return
+	}
+
+	// A seekable reader can be retried on another upstream
+	return r.cli.WriteBatch(tag, r.comp.Len(), bytes.NewReader(r.comp.Bytes()))
+}
+
+// Replays the fallback buffer of a tag. On failure, it's kept for later.
+func (r *Relay) replay(tag string, fb *relayFallback) {
+	err := fb.fb.Reader(func(size int, rd io.Reader) error {
+		return r.cli.WriteBatch(tag, size, rd)
+	})
+
+	if err != nil {
+		r.opt.HandleError(fmt.Errorf("replay of fallback for tag %q failed: %w", tag, err))
+
+		// Moves the remaining data back in place for the next replay
+		if ok, err := fb.fb.HasData(); err != nil {
+			r.opt.HandleError(err)
+		} else {
+			fb.hasData = ok
+		}
+
+		return
+	}
+
+	fb.hasData = false
+}
+
+// Returns the fallback buffer of a tag, if any.
+func (r *Relay) fallback(tag string) (fb *relayFallback, err error) {
+	if r.opt.Fallback == nil {
+		return
+	}
+
+	if fb, ok := r.fallbacks[tag]; ok {
+		return fb, nil
+	}
+
+	f, err := r.opt.Fallback(tag)
+
+	if err != nil {
+		return
+	}
+
+	fb = &relayFallback{fb: f}
+
+	// Any data left since before must be replayed
+	if fb.hasData, err = f.HasData(); err != nil {
+		return nil, err
+	}
+
+	r.fallbacks[tag] = fb
+	return
+}
+
+func (r *Relay) flushInterval() {
+	defer r.wg.Done()
+
+	ticker := time.NewTicker(r.opt.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+
+		case <-r.close:
+			return
+
+		case <-ticker.C:
+			if err := r.Flush(); err != nil {
+				r.opt.HandleError(err)
+			}
+
+		}
+	}
+}
+
+// Close flushes any batched entries, and closes all fallback buffers.
+func (r *Relay) Close() (err error) {
+	close(r.close)
+	r.wg.Wait()
+
+	r.sendMu.Lock()
+	defer r.sendMu.Unlock()
+
+	err = r.flush()
+
+	for _, fb := range r.fallbacks {
+		if e := fb.fb.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+
+	return
+}
+
+func (b *relayBatch) reset() {
+	b.buf = b.buf[:0]
+	b.n = 0
+}
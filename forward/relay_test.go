@@ -0,0 +1,253 @@
+package forward
+
+import (
+	"context"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/webmafia/fluentlog/fallback"
+)
+
+// A batch writer that blocks until released, and reports each written tag.
+type blockingWriter struct {
+	release chan struct{}
+	written chan string
+}
+
+func (w *blockingWriter) WriteBatch(tag string, size int, r io.Reader) (err error) {
+	if _, err = io.Copy(io.Discard, r); err != nil {
+		return
+	}
+
+	<-w.release
+	w.written <- tag
+	return
+}
+
+func TestRelay_AddDuringFlush(t *testing.T) {
+	w := &blockingWriter{
+		release: make(chan struct{}),
+		written: make(chan string, 4),
+	}
+
+	r := NewRelay(w, RelayOptions{FlushInterval: time.Hour})
+	defer r.Close()
+
+	if err := r.add("foo", time.Now(), []byte{0x80}); err != nil {
+		t.Fatal(err)
+	}
+
+	flushed := make(chan error, 1)
+
+	go func() {
+		flushed <- r.Flush()
+	}()
+
+	waitFor(t, "batch to be swapped out", func() bool {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		return r.batches["foo"] == nil || r.batches["foo"].n == 0
+	})
+
+	// Batching must not wait for the upstream
+	added := make(chan error, 1)
+
+	go func() {
+		added <- r.add("bar", time.Now(), []byte{0x80})
+	}()
+
+	select {
+	case err := <-added:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("add blocked by a flush in progress")
+	}
+
+	close(w.release)
+
+	if err := <-flushed; err != nil {
+		t.Fatal(err)
+	}
+
+	if tag := <-w.written; tag != "foo" {
+		t.Fatalf("expected foo, got %q", tag)
+	}
+
+	if err := r.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	if tag := <-w.written; tag != "bar" {
+		t.Fatalf("expected bar, got %q", tag)
+	}
+}
+
+func TestRelay_AckAfterUpstreamAck(t *testing.T) {
+	var (
+		release = make(chan struct{})
+		msgs    = make(chan string, 10)
+	)
+
+	// The upstream holds its acknowledgement until released
+	_, upAddr := testServer(t, ServerOptions{Unauthenticated: true, ManualAck: true}, func(ctx context.Context, ss *ServerSession) error {
+		for e, err := range ss.Entries() {
+			if err != nil {
+				return err
+			}
+
+			o, err := e.Clone()
+
+			if err != nil {
+				return err
+			}
+
+			msgs <- o.Record.Get("message").Str()
+			<-release
+
+			if err = ss.Ack(o.Chunk); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	up := NewClient(upAddr, ClientOptions{Unauthenticated: true, RequireAck: true})
+	defer up.Close()
+
+	r := NewRelay(up)
+	t.Cleanup(func() { r.Close() })
+	defer close(release)
+
+	_, addr := testServer(t, ServerOptions{Unauthenticated: true}, r.Serve)
+
+	cli := NewClient(addr, ClientOptions{Unauthenticated: true, RequireAck: true})
+	defer cli.Close()
+
+	if _, err := cli.Write(testEntry("test", "a")); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := receive(t, msgs); got != "a" {
+		t.Fatalf("expected \"a\" upstream, got %q", got)
+	}
+
+	// The entry has been written upstream, but not yet acknowledged there
+	time.Sleep(50 * time.Millisecond)
+
+	if n := cli.Unacked(); n != 1 {
+		t.Fatalf("expected the downstream chunk to await the upstream ack, got %d unacked", n)
+	}
+
+	release <- struct{}{}
+
+	waitFor(t, "downstream ack", func() bool {
+		return cli.Unacked() == 0
+	})
+}
+
+func TestRelay_Fallback(t *testing.T) {
+	var (
+		dir    = t.TempDir()
+		upAddr = testAddr(t)
+		msgs   = make(chan string, 10)
+	)
+
+	// The upstream is down until started below
+	up := NewClient(upAddr, ClientOptions{
+		Unauthenticated: true,
+		Backoff: Backoff{
+			InitialInterval: 10 * time.Millisecond,
+			NoJitter:        true,
+		},
+	})
+
+	defer up.Close()
+
+	r := NewRelay(up, RelayOptions{
+		FlushInterval: 50 * time.Millisecond,
+		Fallback: func(tag string) (fallback.Fallback, error) {
+			return fallback.NewDirBuffer(path.Join(dir, tag)), nil
+		},
+	})
+
+	t.Cleanup(func() { r.Close() })
+
+	_, addr := testServer(t, ServerOptions{Unauthenticated: true}, r.Serve)
+
+	cli := NewClient(addr, ClientOptions{Unauthenticated: true, RequireAck: true})
+	defer cli.Close()
+
+	if _, err := cli.Write(testEntry("test", "spilled")); err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, "downstream ack", func() bool {
+		return cli.Unacked() == 0
+	})
+
+	// The spilled entry must be on disk once acknowledged, in either file of the buffer
+	data := readGzipFile(t, path.Join(dir, "test", "ping.bin")) + readGzipFile(t, path.Join(dir, "test", "pong.bin"))
+
+	if !strings.Contains(data, "spilled") {
+		t.Fatalf("expected the entry in the fallback buffer, got %q", data)
+	}
+
+	testServer(t, ServerOptions{Unauthenticated: true, Address: upAddr}, func(ctx context.Context, ss *ServerSession) error {
+		return readMessages(ss, msgs)
+	})
+
+	if _, err := cli.Write(testEntry("test", "after")); err != nil {
+		t.Fatal(err)
+	}
+
+	// The fallback buffer is replayed before any later entries
+	for _, msg := range []string{"spilled", "after"} {
+		if got := receive(t, msgs); got != msg {
+			t.Errorf("expected %q upstream, got %q", msg, got)
+		}
+	}
+}
+
+// Reads a gzip file as far as it has been flushed. A missing or empty file has no data.
+func readGzipFile(t *testing.T, name string) string {
+	t.Helper()
+
+	file, err := os.Open(name)
+
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ""
+		}
+
+		t.Fatal(err)
+	}
+
+	defer file.Close()
+
+	gz, err := gzip.NewReader(file)
+
+	if err != nil {
+		if err == io.EOF {
+			return ""
+		}
+
+		t.Fatal(err)
+	}
+
+	data, err := io.ReadAll(gz)
+
+	if err != nil && err != io.ErrUnexpectedEOF {
+		t.Fatal(err)
+	}
+
+	return string(data)
+}
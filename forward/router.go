@@ -0,0 +1,222 @@
+package forward
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+
+	"github.com/webmafia/fluentlog/forward/transport"
+	"github.com/webmafia/fluentlog/pkg/msgpack"
+)
+
+// Handles an entry of a session. In FirstMatch mode, the handler must consume the record.
+type EntryHandler func(ctx context.Context, ss *ServerSession, e *transport.Entry) error
+
+type RouteMode uint8
+
+const (
+	// Each entry is dispatched to the first route that matches its tag.
+	FirstMatch RouteMode = iota
+
+	// Each entry is dispatched to all routes that match its tag, in order.
+	FanOut
+)
+
+// A router dispatches entries to handlers by their tag, using Fluentd-style match patterns.
+// Use Serve as the handler of Server.Listen. Routes must be registered before serving.
+type Router struct {
+	mode   RouteMode
+	routes []route
+	def    EntryHandler
+}
+
+type route struct {
+	pattern *regexp.Regexp
+	handler EntryHandler
+}
+
+func NewRouter(mode RouteMode) *Router {
+	return &Router{mode: mode}
+}
+
+// Registers a handler for a match pattern, where:
+//   - "*" matches a single tag part (e.g. "app.*" matches "app.web", but not "app.web.access")
+//   - "**" matches zero or more tag parts (e.g. "app.**" matches "app", "app.web" and "app.web.access")
+//   - "{a,b}" matches either pattern a or b (e.g. "{web,api}.logs")
+//
+// Multiple patterns can be separated by whitespace, just like in Fluentd's <match>.
+func (r *Router) Handle(pattern string, handler EntryHandler) (err error) {
+	re, err := compilePattern(pattern)
+
+	if err != nil {
+		return
+	}
+
+	r.routes = append(r.routes, route{
+		pattern: re,
+		handler: handler,
+	})
+
+	return
+}
+
+// Registers a handler for entries that don't match any route. Without a default route,
+// such entries are discarded.
+func (r *Router) Default(handler EntryHandler) {
+	r.def = handler
+}
+
+// Serve reads the entries of a session and dispatches them, until an error occurs. It has
+// the signature of a Server.Listen handler.
+func (r *Router) Serve(ctx context.Context, ss *ServerSession) (err error) {
+	var (
+		e       transport.Entry
+		buf     []byte
+		rec     = msgpack.NewIterator(nil)
+		lastTag string
+		matched []EntryHandler
+		cached  bool
+	)
+
+	for {
+		if err = ss.Next(&e); err != nil {
+			return
+		}
+
+		// Consecutive entries usually share the same tag
+		if !cached || e.Tag != lastTag {
+			lastTag = strings.Clone(e.Tag)
+			matched = r.match(matched[:0], e.Tag)
+			cached = true
+		}
+
+		switch len(matched) {
+
+		case 0:
+			e.Record.Skip()
+
+		case 1:
+			err = matched[0](ctx, ss, &e)
+
+		default:
+
+			// Each handler reads its own copy of the record
+			if buf, err = e.Record.AppendValue(buf[:0]); err != nil {
+				return
+			}
+
+			for _, h := range matched {
+				rec.ResetBytes(buf)
+
+				if !rec.Next() {
+					return errors.Join(ErrInvalidEntry, rec.Error())
+				}
+
+				ce := e
+				ce.Record = &rec
+
+				if err = h(ctx, ss, &ce); err != nil {
+					break
+				}
+			}
+
+		}
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Appends the handlers of all routes matching a tag to dst.
+func (r *Router) match(dst []EntryHandler, tag string) []EntryHandler {
+	for i := range r.routes {
+		if r.routes[i].pattern.MatchString(tag) {
+			dst = append(dst, r.routes[i].handler)
+
+			if r.mode == FirstMatch {
+				break
+			}
+		}
+	}
+
+	if len(dst) == 0 && r.def != nil {
+		dst = append(dst, r.def)
+	}
+
+	return dst
+}
+
+// Compiles whitespace separated Fluentd-style match patterns into a regular expression.
+func compilePattern(pattern string) (re *regexp.Regexp, err error) {
+	fields := strings.Fields(pattern)
+
+	if len(fields) == 0 {
+		return nil, errors.New("empty pattern")
+	}
+
+	var sb strings.Builder
+	sb.WriteString("^(?:")
+
+	for i, field := range fields {
+		if i > 0 {
+			sb.WriteByte('|')
+		}
+
+		if err = writePattern(&sb, field); err != nil {
+			return
+		}
+	}
+
+	sb.WriteString(")$")
+
+	return regexp.Compile(sb.String())
+}
+
+func writePattern(sb *strings.Builder, p string) error {
+	var depth int
+
+	for i := 0; i < len(p); i++ {
+		switch c := p[i]; {
+
+		// A trailing ".**" also matches nothing, e.g. "app.**" matches "app"
+		case strings.HasPrefix(p[i:], ".**") && (i+3 == len(p) || p[i+3] == '}' || p[i+3] == ','):
+			sb.WriteString(`(?:\..*)?`)
+			i += 2
+
+		// "**." also matches nothing, e.g. "**.logs" matches "logs"
+		case strings.HasPrefix(p[i:], "**."):
+			sb.WriteString(`(?:.*\.)?`)
+			i += 2
+
+		case strings.HasPrefix(p[i:], "**"):
+			sb.WriteString(`.*`)
+			i++
+
+		case c == '*':
+			sb.WriteString(`[^.]*`)
+
+		case c == '{':
+			sb.WriteString(`(?:`)
+			depth++
+
+		case c == ',' && depth > 0:
+			sb.WriteByte('|')
+
+		case c == '}' && depth > 0:
+			sb.WriteByte(')')
+			depth--
+
+		default:
+			sb.WriteString(regexp.QuoteMeta(p[i : i+1]))
+
+		}
+	}
+
+	if depth != 0 {
+		return errors.New("unbalanced braces in pattern: " + p)
+	}
+
+	return nil
+}
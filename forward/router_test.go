@@ -0,0 +1,55 @@
+package forward
+
+import "testing"
+
+func TestCompilePattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		tag     string
+		match   bool
+	}{
+		{"app.web", "app.web", true},
+		{"app.web", "app.api", false},
+		{"app.*", "app.web", true},
+		{"app.*", "app.web.access", false},
+		{"app.*", "app", false},
+		{"app.web*", "app.webhook", true},
+		{"app.**", "app", true},
+		{"app.**", "app.web", true},
+		{"app.**", "app.web.access", true},
+		{"app.**", "application", false},
+		{"**.logs", "logs", true},
+		{"**.logs", "app.web.logs", true},
+		{"app.**.logs", "app.logs", true},
+		{"app.**.logs", "app.web.logs", true},
+		{"{web,api}.logs", "web.logs", true},
+		{"{web,api}.logs", "api.logs", true},
+		{"{web,api}.logs", "db.logs", false},
+		{"{web.*,api}", "web.access", true},
+		{"app.{web,api.**}", "app.api", true},
+		{"app.{web,api.**}", "app.api.v1", true},
+		{"a b", "b", true},
+		{"a b", "c", false},
+		{"**", "anything.at.all", true},
+	}
+
+	for _, tt := range tests {
+		re, err := compilePattern(tt.pattern)
+
+		if err != nil {
+			t.Fatalf("%q: %v", tt.pattern, err)
+		}
+
+		if match := re.MatchString(tt.tag); match != tt.match {
+			t.Errorf("%q matching %q: expected %v, got %v", tt.pattern, tt.tag, tt.match, match)
+		}
+	}
+}
+
+func TestCompilePatternInvalid(t *testing.T) {
+	for _, pattern := range []string{"", "{a,b", "  "} {
+		if _, err := compilePattern(pattern); err == nil {
+			t.Errorf("%q: expected error", pattern)
+		}
+	}
+}
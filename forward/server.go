@@ -3,15 +3,19 @@ package forward
 import (
 	"context"
 	"crypto/tls"
+	"fmt"
 	"io"
 	"log"
 	"net"
+	"os"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/webmafia/fast"
 	"github.com/webmafia/fast/buffer"
 	"github.com/webmafia/fluentlog/internal/gzip"
+	"github.com/webmafia/fluentlog/internal/zstd"
 	"github.com/webmafia/fluentlog/pkg/msgpack"
 )
 
@@ -20,17 +24,66 @@ type Server struct {
 	bufPool  buffer.Pool
 	iterPool msgpack.IterPool
 	gzipPool gzip.Pool
+	zstdPool zstd.Pool
 	sessId   uint64
+	mu       sync.Mutex
+	sessions map[uint64]*ServerSession
+	active   int // Number of sessions, including those not yet added
+	perIP    map[string]int
+	buckets  map[string]*tokenBucket // Rate limits per username
+	wg       sync.WaitGroup
+	closing  bool
+	stop     context.CancelFunc
 }
 
 type ServerOptions struct {
-	Address      string
+	Address      string      // TCP address ("host:port"), or Unix domain socket ("unix:///path/to/socket").
+	SocketMode   os.FileMode // Permissions of the Unix socket file, if any. Zero keeps the umask default.
 	Hostname     string
 	Tls          *tls.Config // E.g. from golang.org/x/crypto/acme/autocert
 	HandleError  func(err error)
 	Auth         AuthServer
 	PasswordAuth bool
-	ReadTimeout  time.Duration
+	ReadTimeout  time.Duration // Max time to wait for data. Between chunks, Next then fails with ErrReadTimeout and can be retried.
+
+	// Authenticate clients by their TLS certificate, which then sets the username of the
+	// session. Unless Unauthenticated, the shared key handshake is required as well, where
+	// any username of PasswordAuth must match the certificate. Tls.ClientAuth is raised to
+	// tls.RequireAndVerifyClientCert unless it already verifies certificates.
+	CertAuth CertAuthServer
+
+	// Rules of which clients may connect by their source IP, where the first rule that matches
+	// a client applies. Once there's any allowing rule, clients that match no rule are denied.
+	// Denied connections are closed right away, and passed to HandleError as ErrClientDenied.
+	Clients []ClientRule
+
+	// Connections start with a PROXY protocol header (v1 or v2) of a load balancer, whose
+	// source address is then the remote address of the session (e.g. for Clients and
+	// MaxSessionsPerIP). The listener must then only be reachable through the load balancer.
+	ProxyProtocol bool
+
+	// Let the handler acknowledge chunks with ServerSession.Ack once their entries have been
+	// durably stored, rather than acknowledging them as soon as they have been read.
+	ManualAck bool
+
+	// Skip the HELO/PING/PONG handshake and accept entries right away, like a
+	// Fluentd/Fluent Bit forward input without a shared key.
+	Unauthenticated bool
+
+	// Limits, where zero means unlimited. Any violation is passed to HandleError as one of
+	// ErrTooManySessions, ErrIdleTimeout, transport.ErrMessageTooLarge or
+	// transport.ErrDecompressedTooLarge, and the session is closed.
+	MaxSessions         int           // Max number of concurrent sessions.
+	MaxSessionsPerIP    int           // Max number of concurrent sessions per source IP.
+	MaxMessageSize      int           // Max size in bytes of a binary chunk, before decompression.
+	MaxDecompressedSize int           // Max size in bytes of a chunk, after decompression.
+	IdleTimeout         time.Duration // Max time between entries, after which the session is closed.
+
+	// Max number of entries per second of each session, which is throttled (rather than
+	// closed) when exceeded. RateBurst defaults to the rate limit.
+	RateLimit        float64
+	RateBurst        int
+	RateLimitPerUser bool // Share the rate limit between all sessions of the same username.
 }
 
 func SharedKey(sharedKey []byte) func(clientHostname string) (sharedKey []byte, err error) {
@@ -48,70 +101,351 @@ func NewServer(opt ServerOptions) *Server {
 		opt.HandleError = func(err error) {}
 	}
 
+	if opt.CertAuth != nil {
+		opt.Tls = certAuthTls(opt.Tls)
+	}
+
 	return &Server{
-		opt: opt,
+		opt:      opt,
+		sessions: make(map[uint64]*ServerSession),
+		perIP:    make(map[string]int),
+		buckets:  make(map[string]*tokenBucket),
 		// iterPool: msgpack.IterPool{
 		// 	BufMaxSize: 16 * 1024, // 16 kB
 		// },
 	}
 }
 
+// Listens for connections until either ctx is cancelled, or the server is shut down (in
+// which case ErrServerClosed is returned). Sessions are cancelled along with ctx.
 func (s *Server) Listen(ctx context.Context, handler func(ctx context.Context, ss *ServerSession) error) (err error) {
+	sessCtx := ctx
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
-	var lc net.ListenConfig
-	listener, err := lc.Listen(ctx, "tcp", s.opt.Address)
-
-	if err != nil {
-		return
+	if s.opt.CertAuth != nil && s.opt.Tls == nil {
+		return fmt.Errorf("%w: client certificates without TLS", ErrNotSupported)
 	}
 
-	if s.opt.Tls != nil {
-		listener = tls.NewListener(listener, s.opt.Tls)
+	if err = s.start(cancel); err != nil {
+		return
 	}
 
-	heartbeat, err := s.listenHeartbeat(ctx)
+	listener, err := s.listen(ctx)
 
 	if err != nil {
 		return
 	}
 
+	var heartbeat *net.UDPConn
+
+	// Heartbeats are sent over UDP on the same port, which doesn't exist for Unix sockets.
+	if network, _ := splitNetwork(s.opt.Address); network == "tcp" {
+		if heartbeat, err = s.listenHeartbeat(ctx); err != nil {
+			listener.Close()
+			return
+		}
+	}
+
 	go func() {
 		<-ctx.Done()
-		heartbeat.Close()
+
+		if heartbeat != nil {
+			heartbeat.Close()
+		}
+
 		listener.Close()
 		log.Println("Closed server")
 	}()
 
 	log.Println("Listening on", s.opt.Address)
 
+	return s.accept(sessCtx, listener, nil, handler, func(ctx context.Context, ss *ServerSession) (err error) {
+		if s.opt.CertAuth != nil {
+			if err = ss.authenticateCert(ctx); err != nil {
+				return
+			}
+		}
+
+		if !s.opt.Unauthenticated {
+			if err = ss.authenticate(ctx); err != nil {
+				return
+			}
+		}
+
+		ss.initTransportPhase()
+		return
+	})
+}
+
+// Registers the cancellation of a listener, that is called on shutdown.
+func (s *Server) start(cancel context.CancelFunc) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closing {
+		return ErrServerClosed
+	}
+
+	s.stop = cancel
+	return nil
+}
+
+func (s *Server) listen(ctx context.Context) (listener net.Listener, err error) {
+	var lc net.ListenConfig
+	network, addr := splitNetwork(s.opt.Address)
+
+	if network == "unix" {
+		if err = removeStaleSocket(addr); err != nil {
+			return
+		}
+	}
+
+	if listener, err = lc.Listen(ctx, network, addr); err != nil {
+		return
+	}
+
+	// The socket file is removed when the listener is closed.
+	if network == "unix" && s.opt.SocketMode != 0 {
+		if err = os.Chmod(addr, s.opt.SocketMode); err != nil {
+			listener.Close()
+			return
+		}
+	}
+
+	// The header precedes any TLS handshake
+	if s.opt.ProxyProtocol {
+		listener = newProxyListener(listener, s.opt.HandleError)
+	}
+
+	if s.opt.Tls != nil {
+		listener = tls.NewListener(listener, s.opt.Tls)
+	}
+
+	return
+}
+
+// Accepts connections until the listener is closed, and serves each of them in a session
+// that is initialized by init before being passed to the handler. Sessions read Forward
+// entries, unless source returns an entry source for their connection.
+func (s *Server) accept(ctx context.Context, listener net.Listener, source func(conn net.Conn) entrySource, handler, init func(ctx context.Context, ss *ServerSession) error) error {
 	for {
 		conn, err := listener.Accept()
 
 		if err != nil {
+			if s.isClosing() {
+				return ErrServerClosed
+			}
+
 			return err
 		}
 
-		go func() {
-			ss := newServerSession(s, conn)
-			defer ss.Close()
-
-			ss.id = atomic.AddUint64(&s.sessId, 1)
+		ip, err := s.acquireSession(conn)
 
-			ctx, cancel := context.WithCancel(ctx)
-			defer cancel()
+		if err != nil {
+			conn.Close()
 
-			if err := ss.authenticate(ctx); err != nil {
-				s.opt.HandleError(err)
-				return
+			if err == ErrServerClosed {
+				return err
 			}
 
-			ss.initTransportPhase()
+			s.opt.HandleError(err)
+			continue
+		}
+
+		var src entrySource
+
+		if source != nil {
+			src = source(conn)
+		}
+
+		go s.serve(ctx, conn, ip, src, handler, init)
+	}
+}
+
+// Serves a connection that has been reserved by acquireSession, and returns any error that
+// has been passed to HandleError.
+func (s *Server) serve(ctx context.Context, conn net.Conn, ip string, src entrySource, handler, init func(ctx context.Context, ss *ServerSession) error) (err error) {
+	defer s.releaseSession(ip)
+
+	ss := newServerSession(s, conn)
+	ss.src = src
+	ss.client, _ = s.matchClient(conn.RemoteAddr())
+	defer ss.Close()
+
+	ss.id = atomic.AddUint64(&s.sessId, 1)
+	s.addSession(&ss)
+	defer s.removeSession(&ss)
+
+	// Sessions are not cancelled along with the listener, to allow a graceful shutdown
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Cancellation interrupts any throttling
+	stopInterrupt := context.AfterFunc(ctx, ss.interrupt)
+	defer stopInterrupt()
+
+	if err = init(ctx, &ss); err != nil {
+		s.opt.HandleError(err)
+		return
+	}
+
+	if err = handler(ctx, fast.Noescape(&ss)); err != nil {
+		if err == io.EOF {
+			return nil
+		}
+
+		s.opt.HandleError(err)
+	}
+
+	return
+}
+
+func (s *Server) isClosing() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.closing
+}
+
+// Reserves a session for a new connection, unless any limit is reached.
+func (s *Server) acquireSession(conn net.Conn) (ip string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closing {
+		return "", ErrServerClosed
+	}
+
+	if _, ok := s.matchClient(conn.RemoteAddr()); !ok {
+		return "", fmt.Errorf("%w: rejected %s", ErrClientDenied, conn.RemoteAddr())
+	}
+
+	if s.opt.MaxSessions > 0 && s.active >= s.opt.MaxSessions {
+		return "", fmt.Errorf("%w: max %d sessions, rejected %s", ErrTooManySessions, s.opt.MaxSessions, conn.RemoteAddr())
+	}
+
+	// Unix sockets have no source IP
+	if tcp, ok := conn.RemoteAddr().(*net.TCPAddr); ok && s.opt.MaxSessionsPerIP > 0 {
+		ip = tcp.IP.String()
+
+		if s.perIP[ip] >= s.opt.MaxSessionsPerIP {
+			return "", fmt.Errorf("%w: max %d sessions per IP, rejected %s", ErrTooManySessions, s.opt.MaxSessionsPerIP, ip)
+		}
+
+		s.perIP[ip]++
+	}
+
+	s.active++
+	s.wg.Add(1)
+	return
+}
+
+func (s *Server) releaseSession(ip string) {
+	s.mu.Lock()
+	s.active--
+
+	if ip != "" {
+		if s.perIP[ip]--; s.perIP[ip] <= 0 {
+			delete(s.perIP, ip)
+		}
+	}
+
+	s.mu.Unlock()
+	s.wg.Done()
+}
+
+// Returns the rate limit of a session, if any.
+func (s *Server) rateLimit(username string) *tokenBucket {
+	if s.opt.RateLimit <= 0 {
+		return nil
+	}
+
+	if !s.opt.RateLimitPerUser || username == "" {
+		return newTokenBucket(s.opt.RateLimit, s.opt.RateBurst)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, ok := s.buckets[username]
+
+	if !ok {
+		b = newTokenBucket(s.opt.RateLimit, s.opt.RateBurst)
+		s.buckets[username] = b
+	}
+
+	b.sessions++
+	return b
+}
+
+func (s *Server) addSession(ss *ServerSession) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sessions[ss.id] = ss
+
+	// Shutdown might have been called right after the connection was accepted
+	if s.closing {
+		ss.stop()
+	}
+}
+
+func (s *Server) removeSession(ss *ServerSession) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.sessions, ss.id)
+
+	// The rate limit of a username is dropped along with its last session
+	if b := ss.bucket; b != nil && b.sessions > 0 {
+		if b.sessions--; b.sessions == 0 {
+			delete(s.buckets, ss.Username())
+		}
+	}
+}
+
+// Shutdown gracefully shuts down the server. It stops accepting connections, and lets each
+// session finish (and acknowledge) its current chunk, while idle sessions are closed right
+// away. It then waits for all handlers to return. If ctx expires first, any remaining
+// connections are closed and the context's error is returned. A server can't be reused
+// once shut down.
+func (s *Server) Shutdown(ctx context.Context) (err error) {
+	s.mu.Lock()
+	s.closing = true
+
+	if s.stop != nil {
+		s.stop()
+	}
+
+	for _, ss := range s.sessions {
+		ss.stop()
+	}
+
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+
+	case <-done:
+		return
+
+	case <-ctx.Done():
+		s.mu.Lock()
+
+		for _, ss := range s.sessions {
+			ss.conn.Close()
+			ss.interrupt()
+		}
+
+		s.mu.Unlock()
+		return ctx.Err()
 
-			if err := handler(ctx, fast.Noescape(&ss)); err != nil && err != io.EOF {
-				s.opt.HandleError(err)
-			}
-		}()
 	}
 }
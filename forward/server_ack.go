@@ -0,0 +1,116 @@
+package forward
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Chunks awaiting acknowledgement by the handler, when using ManualAck.
+type manualAck struct {
+	mu      sync.Mutex
+	pending []pendingAck
+	acked   uint64        // Chunks up to and including this sequence number are acknowledged
+	nacked  atomic.Uint64 // Chunks from this sequence number and on are rejected
+}
+
+type pendingAck struct {
+	seq uint64
+	id  string
+}
+
+// Called by the transport phase once the chunk ID of a chunk has been read.
+func (ss *ServerSession) deferAck(seq uint64, chunk string) (err error) {
+	a := &ss.acks
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if n := a.nacked.Load(); n != 0 && seq >= n {
+		return
+	}
+
+	// The handler might already have acknowledged the chunk while handling its last entry
+	if seq <= a.acked {
+		return ss.writeAck(chunk)
+	}
+
+	a.pending = append(a.pending, pendingAck{
+		seq: seq,
+		id:  strings.Clone(chunk),
+	})
+
+	return
+}
+
+// Ack acknowledges all chunks up to and including seq (see transport.Chunk.Seq) when using
+// ManualAck, and should be called once their entries have been durably stored. A chunk whose
+// options haven't been read yet is acknowledged as soon as they are. Without ManualAck, chunks
+// are acknowledged as soon as they have been read, and this is a no-op. Safe to call from
+// another goroutine.
+func (ss *ServerSession) Ack(seq uint64) (err error) {
+	if !ss.serv.opt.ManualAck {
+		return
+	}
+
+	if seq > ss.trans.Chunk() {
+		return fmt.Errorf("%w: %d", ErrUnknownChunk, seq)
+	}
+
+	a := &ss.acks
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if n := a.nacked.Load(); n != 0 && seq >= n {
+		return fmt.Errorf("%w: %d", ErrChunkRejected, seq)
+	}
+
+	if seq <= a.acked {
+		return
+	}
+
+	a.acked = seq
+
+	var i int
+
+	for ; i < len(a.pending) && a.pending[i].seq <= seq; i++ {
+		if err = ss.writeAck(a.pending[i].id); err != nil {
+			break
+		}
+	}
+
+	a.pending = a.pending[:copy(a.pending, a.pending[i:])]
+	return
+}
+
+// AckAll acknowledges all chunks read so far.
+func (ss *ServerSession) AckAll() error {
+	return ss.Ack(ss.trans.Chunk())
+}
+
+// Nack rejects chunk seq, along with any later chunks, which will never be acknowledged. The
+// session is interrupted, so that Next fails with ErrChunkRejected - the handler should then
+// return, after which the connection is closed and the client retransmits any unacknowledged
+// chunks. Returning an error from the handler has the same effect. Safe to call from another
+// goroutine.
+func (ss *ServerSession) Nack(seq uint64) {
+	if !ss.acks.nacked.CompareAndSwap(0, seq) {
+		return
+	}
+
+	ss.conn.SetReadDeadline(time.Now())
+	ss.interrupt()
+}
+
+// Returns the number of read chunks that await acknowledgement by the handler.
+func (ss *ServerSession) Unacked() int {
+	ss.acks.mu.Lock()
+	defer ss.acks.mu.Unlock()
+
+	return len(ss.acks.pending)
+}
+
+func (ss *ServerSession) rejected() bool {
+	return ss.acks.nacked.Load() != 0
+}
@@ -0,0 +1,187 @@
+package forward
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestServer_ManualAck(t *testing.T) {
+	sessions := make(chan *ServerSession, 1)
+	chunks := make(chan uint64, 10)
+
+	_, addr := testServer(t, ServerOptions{Unauthenticated: true, ManualAck: true}, func(ctx context.Context, ss *ServerSession) error {
+		sessions <- ss
+
+		for e, err := range ss.Entries() {
+			if err != nil {
+				return err
+			}
+
+			e.Record.Skip()
+			chunks <- e.Chunk.Seq
+		}
+
+		return nil
+	})
+
+	cli := NewClient(addr, ClientOptions{Unauthenticated: true, RequireAck: true})
+	defer cli.Close()
+
+	write := func(msgs ...string) (seqs []uint64) {
+		t.Helper()
+
+		for _, msg := range msgs {
+			if _, err := cli.Write(testEntry("test", msg)); err != nil {
+				t.Fatal(err)
+			}
+
+			select {
+			case seq := <-chunks:
+				seqs = append(seqs, seq)
+			case <-time.After(5 * time.Second):
+				t.Fatal("timed out waiting for a chunk")
+			}
+		}
+
+		return
+	}
+
+	seqs := write("a", "b", "c")
+	ss := <-sessions
+
+	waitFor(t, "chunks to await acknowledgement", func() bool {
+		return ss.Unacked() == 3
+	})
+
+	// Nothing is acknowledged until the handler does so
+	if n := cli.Unacked(); n != 3 {
+		t.Fatalf("expected 3 unacknowledged chunks, got %d", n)
+	}
+
+	if err := ss.Ack(seqs[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, "the first acknowledgement", func() bool {
+		return cli.Unacked() == 2
+	})
+
+	if n := ss.Unacked(); n != 2 {
+		t.Errorf("expected 2 chunks awaiting acknowledgement, got %d", n)
+	}
+
+	if err := ss.Ack(seqs[2] + 1); !errors.Is(err, ErrUnknownChunk) {
+		t.Errorf("expected ErrUnknownChunk, got %v", err)
+	}
+
+	write("d")
+
+	waitFor(t, "chunks to await acknowledgement", func() bool {
+		return ss.Unacked() == 3
+	})
+
+	// Acknowledges all outstanding chunks
+	if err := ss.AckAll(); err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, "all acknowledgements", func() bool {
+		return cli.Unacked() == 0
+	})
+
+	if n := ss.Unacked(); n != 0 {
+		t.Errorf("expected no chunks awaiting acknowledgement, got %d", n)
+	}
+}
+
+func TestServer_Nack(t *testing.T) {
+	var sessions atomic.Int32
+
+	first := make(chan string, 10)
+	second := make(chan string, 100)
+	rejected := make(chan error, 1)
+
+	_, addr := testServer(t, ServerOptions{Unauthenticated: true, ManualAck: true}, func(ctx context.Context, ss *ServerSession) error {
+		msgs := second
+
+		if sessions.Add(1) == 1 {
+			msgs = first
+		}
+
+		for e, err := range ss.Entries() {
+			if err != nil {
+				if msgs == first {
+					rejected <- err
+				}
+
+				return err
+			}
+
+			o, err := e.Clone()
+
+			if err != nil {
+				return err
+			}
+
+			msg := o.Record.Get("message").Str()
+			msgs <- msg
+
+			// The first session rejects "b", which can then no longer be acknowledged
+			if msgs == first && msg == "b" {
+				ss.Nack(o.Chunk)
+
+				if err = ss.Ack(o.Chunk); !errors.Is(err, ErrChunkRejected) {
+					t.Errorf("expected ErrChunkRejected, got %v", err)
+				}
+
+				continue
+			}
+
+			if err = ss.Ack(o.Chunk); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	cli := NewClient(addr, ClientOptions{Unauthenticated: true, RequireAck: true})
+	defer cli.Close()
+
+	for _, msg := range []string{"a", "b"} {
+		if _, err := cli.Write(testEntry("test", msg)); err != nil {
+			t.Fatal(err)
+		}
+
+		if got := receive(t, first); got != msg {
+			t.Fatalf("expected %q, got %q", msg, got)
+		}
+	}
+
+	if err := receiveErr(t, rejected); !errors.Is(err, ErrChunkRejected) {
+		t.Fatalf("expected ErrChunkRejected, got %v", err)
+	}
+
+	// Only the rejected chunk is left unacknowledged
+	if n := cli.Unacked(); n != 1 {
+		t.Fatalf("expected 1 unacknowledged chunk, got %d", n)
+	}
+
+	// Once the connection has been closed, a write fails and the next one reconnects
+	waitFor(t, "reconnect", func() bool {
+		_, err := cli.Write(testEntry("test", "c"))
+		return err == nil && sessions.Load() == 2
+	})
+
+	// Only the rejected chunk is retransmitted
+	if got := receive(t, second); got != "b" {
+		t.Errorf("expected \"b\" to be retransmitted, got %q", got)
+	}
+
+	waitFor(t, "acknowledgements", func() bool {
+		return cli.Unacked() == 0
+	})
+}
@@ -0,0 +1,103 @@
+package forward
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+)
+
+// Identity of a verified client certificate.
+type CertIdentity struct {
+	CommonName     string
+	DNSNames       []string
+	EmailAddresses []string
+	URIs           []string
+	Cert           *x509.Certificate
+}
+
+func newCertIdentity(cert *x509.Certificate) CertIdentity {
+	id := CertIdentity{
+		CommonName:     cert.Subject.CommonName,
+		DNSNames:       cert.DNSNames,
+		EmailAddresses: cert.EmailAddresses,
+		Cert:           cert,
+	}
+
+	for _, uri := range cert.URIs {
+		id.URIs = append(id.URIs, uri.String())
+	}
+
+	return id
+}
+
+// Returns all names of the identity, i.e. the common name followed by any SANs.
+func (id CertIdentity) Names() []string {
+	names := make([]string, 0, 1+len(id.DNSNames)+len(id.EmailAddresses)+len(id.URIs))
+
+	if id.CommonName != "" {
+		names = append(names, id.CommonName)
+	}
+
+	names = append(names, id.DNSNames...)
+	names = append(names, id.EmailAddresses...)
+	return append(names, id.URIs...)
+}
+
+// Maps the names of client certificates (common name or any SAN) to usernames. Certificates
+// without any known name are rejected.
+func StaticCertAuthServer(usernames map[string]string) CertAuthServer {
+	return func(_ context.Context, id CertIdentity) (string, error) {
+		for _, name := range id.Names() {
+			if username, ok := usernames[name]; ok {
+				return username, nil
+			}
+		}
+
+		return "", fmt.Errorf("unknown certificate '%s'", id.CommonName)
+	}
+}
+
+// Authenticates the session by its verified client certificate.
+func (ss *ServerSession) authenticateCert(ctx context.Context) (err error) {
+	conn, ok := ss.conn.(*tls.Conn)
+
+	if !ok {
+		return fmt.Errorf("%w: client certificates require TLS", ErrFailedAuth)
+	}
+
+	if err = conn.HandshakeContext(ctx); err != nil {
+		return errors.Join(ErrFailedAuth, err)
+	}
+
+	chains := conn.ConnectionState().VerifiedChains
+
+	if len(chains) == 0 || len(chains[0]) == 0 {
+		return fmt.Errorf("%w: no verified client certificate", ErrFailedAuth)
+	}
+
+	username, err := ss.serv.opt.CertAuth(ctx, newCertIdentity(chains[0][0]))
+
+	if err != nil {
+		return errors.Join(ErrFailedAuth, err)
+	}
+
+	if err = ss.allowUser(username); err != nil {
+		return
+	}
+
+	ss.user = append(ss.user[:0], username...)
+	return
+}
+
+// Ensures that the server requests and verifies client certificates.
+func certAuthTls(conf *tls.Config) *tls.Config {
+	if conf == nil || conf.ClientAuth >= tls.VerifyClientCertIfGiven {
+		return conf
+	}
+
+	conf = conf.Clone()
+	conf.ClientAuth = tls.RequireAndVerifyClientCert
+	return conf
+}
@@ -0,0 +1,46 @@
+package forward
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"testing"
+)
+
+func TestStaticCertAuthServer(t *testing.T) {
+	auth := StaticCertAuthServer(map[string]string{
+		"app-1":                "app1",
+		"spiffe://example/app": "app2",
+	})
+
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "app-1"}}
+
+	if username, err := auth(context.Background(), newCertIdentity(cert)); err != nil || username != "app1" {
+		t.Errorf("common name: got %q, %v", username, err)
+	}
+
+	id := CertIdentity{CommonName: "other", URIs: []string{"spiffe://example/app"}}
+
+	if username, err := auth(context.Background(), id); err != nil || username != "app2" {
+		t.Errorf("URI SAN: got %q, %v", username, err)
+	}
+
+	if _, err := auth(context.Background(), CertIdentity{CommonName: "other"}); err == nil {
+		t.Error("expected unknown certificate to be rejected")
+	}
+}
+
+func TestCertAuthTls(t *testing.T) {
+	conf := &tls.Config{}
+
+	if c := certAuthTls(conf); c.ClientAuth != tls.RequireAndVerifyClientCert || conf.ClientAuth != tls.NoClientCert {
+		t.Errorf("expected a clone that requires client certificates, got %s", c.ClientAuth)
+	}
+
+	conf.ClientAuth = tls.VerifyClientCertIfGiven
+
+	if c := certAuthTls(conf); c != conf {
+		t.Error("expected config that verifies client certificates to be kept")
+	}
+}
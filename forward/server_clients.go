@@ -0,0 +1,68 @@
+package forward
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+)
+
+// A rule of which clients may connect from a network, like a <client> of Fluentd's
+// <security>.
+type ClientRule struct {
+	Network   netip.Prefix // E.g. netip.MustParsePrefix("10.0.0.0/8")
+	Deny      bool         // Reject clients of the network, rather than allowing them.
+	SharedKey string       // Shared key of clients of the network, instead of the one of Auth.
+	Users     []string     // Usernames allowed from the network, where empty allows any.
+}
+
+// Returns the first rule that matches the address of a client, and whether the client is
+// allowed. Once there's any allowing rule, clients that match no rule are denied. Addresses
+// without an IP (i.e. of Unix sockets) are always allowed.
+func (s *Server) matchClient(addr net.Addr) (rule *ClientRule, ok bool) {
+	if len(s.opt.Clients) == 0 {
+		return nil, true
+	}
+
+	ip, ok := addrIP(addr)
+
+	if !ok {
+		return nil, true
+	}
+
+	ok = true
+
+	for i := range s.opt.Clients {
+		rule = &s.opt.Clients[i]
+
+		if rule.Network.Contains(ip) {
+			return rule, !rule.Deny
+		}
+
+		if !rule.Deny {
+			ok = false
+		}
+	}
+
+	return nil, ok
+}
+
+func addrIP(addr net.Addr) (ip netip.Addr, ok bool) {
+	switch addr := addr.(type) {
+	case *net.TCPAddr:
+		ip, ok = netip.AddrFromSlice(addr.IP)
+	case *net.UDPAddr:
+		ip, ok = netip.AddrFromSlice(addr.IP)
+	}
+
+	return ip.Unmap(), ok
+}
+
+// Returns an error if the username isn't allowed from the network of the client.
+func (ss *ServerSession) allowUser(username string) error {
+	if ss.client == nil || len(ss.client.Users) == 0 || slices.Contains(ss.client.Users, username) {
+		return nil
+	}
+
+	return fmt.Errorf("%w: username '%s' isn't allowed from %s", ErrFailedAuth, username, ss.RemoteAddr())
+}
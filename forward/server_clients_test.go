@@ -0,0 +1,46 @@
+package forward
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+)
+
+func TestServerMatchClient(t *testing.T) {
+	s := NewServer(ServerOptions{
+		Clients: []ClientRule{
+			{Network: netip.MustParsePrefix("10.0.0.13/32"), Deny: true},
+			{Network: netip.MustParsePrefix("10.0.0.0/8"), Users: []string{"app"}},
+			{Network: netip.MustParsePrefix("2001:db8::/32"), SharedKey: "other"},
+		},
+	})
+
+	tests := []struct {
+		addr  net.Addr
+		rule  int // Index of the matching rule, or -1
+		allow bool
+	}{
+		{&net.TCPAddr{IP: net.ParseIP("10.0.0.13")}, 0, false},
+		{&net.TCPAddr{IP: net.ParseIP("10.1.2.3")}, 1, true},
+		{&net.TCPAddr{IP: net.ParseIP("::ffff:10.1.2.3")}, 1, true},
+		{&net.TCPAddr{IP: net.ParseIP("2001:db8::1")}, 2, true},
+		{&net.TCPAddr{IP: net.ParseIP("192.0.2.1")}, -1, false},
+		{&net.UnixAddr{Name: "/tmp/fluent.sock", Net: "unix"}, -1, true},
+	}
+
+	for _, tt := range tests {
+		rule, allow := s.matchClient(tt.addr)
+
+		if allow != tt.allow || (tt.rule < 0) != (rule == nil) || (rule != nil && rule != &s.opt.Clients[tt.rule]) {
+			t.Errorf("%s: got rule %v and %t, expected rule %d and %t", tt.addr, rule, allow, tt.rule, tt.allow)
+		}
+	}
+
+	s = NewServer(ServerOptions{
+		Clients: []ClientRule{{Network: netip.MustParsePrefix("10.0.0.0/8"), Deny: true}},
+	})
+
+	if _, allow := s.matchClient(&net.TCPAddr{IP: net.ParseIP("192.0.2.1")}); !allow {
+		t.Error("expected clients that match no rule to be allowed without allowing rules")
+	}
+}
@@ -3,9 +3,13 @@ package forward
 import (
 	"context"
 	"crypto/rand"
+	"errors"
 	"fmt"
+	"io"
+	"iter"
 	"log"
 	"net"
+	"os"
 	"time"
 
 	_ "unsafe"
@@ -22,13 +26,29 @@ type ServerSession struct {
 	write    msgpack.Buffer
 	user     []byte
 	timeConn time.Time
+	lastRead time.Time // Time of the last entry
+	bucket   *tokenBucket
+	onAck    func(chunk string) error // Called instead of acknowledging a chunk right away
+	onChunk  func(c *transport.Chunk) error
+	acks     manualAck
 	trans    transport.TransportPhase
+	src      entrySource   // Reads entries of another protocol than Forward (e.g. syslog), if set
+	client   *ClientRule   // Rule that matched the client, if any
+	wake     chan struct{} // Interrupts throttling
 	id       uint64
 }
 
+// Reads entries of another protocol than Forward, which has no chunks.
+type entrySource interface {
+	Next(e *transport.Entry) error
+	Rewind()    // Rewinds the record of the current entry
+	Idle() bool // Whether waiting for the next entry, i.e. whether a blocking read can be interrupted
+}
+
 //go:linkname newServerSession forward.newServerSession
 func newServerSession(s *Server, conn net.Conn) ServerSession {
 	iter := s.iterPool.Get(conn)
+	now := time.Now()
 	wBuf := s.bufPool.Get()
 
 	return ServerSession{
@@ -36,7 +56,9 @@ func newServerSession(s *Server, conn net.Conn) ServerSession {
 		conn:     conn,
 		iter:     iter,
 		write:    msgpack.Buffer{Buffer: wBuf},
-		timeConn: time.Now(),
+		timeConn: now,
+		lastRead: now,
+		wake:     make(chan struct{}, 1),
 	}
 }
 
@@ -59,6 +81,19 @@ func (ss *ServerSession) authenticate(ctx context.Context) (err error) {
 
 	salt, cred, err := ss.readPing(ctx, nonce, auth)
 
+	// The username of a client certificate takes precedence
+	if err == nil && ss.serv.opt.CertAuth != nil {
+		if auth != "" && cred.Username != ss.Username() {
+			err = fmt.Errorf("%w: username '%s' doesn't match client certificate", ErrFailedAuth, cred.Username)
+		} else {
+			cred.Username = ss.Username()
+		}
+	}
+
+	if err == nil {
+		err = ss.allowUser(cred.Username)
+	}
+
 	if err != nil {
 		ss.writePong(nonce, salt, "", false, err.Error())
 		return
@@ -85,6 +120,12 @@ func (ss *ServerSession) Username() string {
 	return fast.BytesToString(ss.user)
 }
 
+// Returns the address of the client, which is the source address of any PROXY protocol
+// header rather than of the load balancer.
+func (ss *ServerSession) RemoteAddr() net.Addr {
+	return ss.conn.RemoteAddr()
+}
+
 func (ss *ServerSession) ID() uint64 {
 	return ss.id
 }
@@ -102,24 +143,198 @@ func (ss *ServerSession) Log(str string, args ...any) {
 }
 
 func (ss *ServerSession) initTransportPhase() {
-	ss.trans.Init(&ss.serv.iterPool, &ss.serv.gzipPool, func(chunk string) (err error) {
-		ss.write.WriteMapHeader(1)
-		ss.write.WriteString("ack")
-		ss.write.WriteString(chunk)
+	ss.bucket = ss.serv.rateLimit(ss.Username())
+	ss.trans.SetLimits(transport.Limits{
+		MaxMessageSize:      ss.serv.opt.MaxMessageSize,
+		MaxDecompressedSize: ss.serv.opt.MaxDecompressedSize,
+	})
+
+	ss.trans.Init(&ss.serv.iterPool, &ss.serv.gzipPool, &ss.serv.zstdPool, ss.chunkDone)
+}
 
-		_, err = ss.write.WriteTo(ss.conn)
+// Registers a function that is called once each chunk has been read, including its options,
+// and before it's acknowledged. Returning an error fails the session without acknowledging
+// the chunk.
+func (ss *ServerSession) OnChunk(fn func(c *transport.Chunk) error) {
+	ss.onChunk = fn
+}
+
+func (ss *ServerSession) chunkDone(c *transport.Chunk) (err error) {
+	if ss.onChunk != nil {
+		if err = ss.onChunk(c); err != nil {
+			return
+		}
+	}
+
+	if c.ID == "" {
 		return
-	})
+	}
+
+	if ss.onAck != nil {
+		return ss.onAck(c.ID)
+	}
+
+	if ss.serv.opt.ManualAck {
+		return ss.deferAck(c.Seq, c.ID)
+	}
+
+	return ss.writeAck(c.ID)
+}
+
+func (ss *ServerSession) writeAck(chunk string) (err error) {
+	ss.write.WriteMapHeader(1)
+	ss.write.WriteString("ack")
+	ss.write.WriteString(chunk)
+
+	_, err = ss.write.WriteTo(ss.conn)
+	return
 }
 
 func (ss *ServerSession) Next(e *transport.Entry) (err error) {
-	if ss.serv.opt.ReadTimeout > 0 {
-		dur := time.Since(ss.timeConn)
-		dur += ss.serv.opt.ReadTimeout
-		ss.conn.SetReadDeadline(ss.timeConn.Add(dur))
+	opt := &ss.serv.opt
+
+	if opt.ReadTimeout > 0 || opt.IdleTimeout > 0 {
+		var deadline time.Time
+
+		if opt.ReadTimeout > 0 {
+			deadline = time.Now().Add(opt.ReadTimeout)
+		}
+
+		if opt.IdleTimeout > 0 {
+			if idle := ss.lastRead.Add(opt.IdleTimeout); deadline.IsZero() || idle.Before(deadline) {
+				deadline = idle
+			}
+		}
+
+		ss.conn.SetReadDeadline(deadline)
+	}
+
+	// Checked after setting the deadline, as Nack interrupts by resetting it
+	if ss.rejected() {
+		return ErrChunkRejected
 	}
 
-	return ss.trans.Next(ss.iter, e)
+	if err = ss.read(e); err != nil {
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			if ss.rejected() {
+				err = ErrChunkRejected
+			} else if ss.trans.Stopped() {
+				err = io.EOF
+			} else if opt.IdleTimeout > 0 && time.Since(ss.lastRead) >= opt.IdleTimeout {
+				err = fmt.Errorf("%w after %s", ErrIdleTimeout, opt.IdleTimeout)
+			} else if ss.idle() {
+				err = fmt.Errorf("%w: %w", ErrReadTimeout, err)
+			}
+		}
+
+		return
+	}
+
+	if err = ss.throttle(); err != nil {
+		return
+	}
+
+	ss.lastRead = time.Now()
+
+	return
+}
+
+// Throttles the session by delaying the entry until it's within the rate limit. The delay is
+// cut short by a shutdown (after which the session is no longer throttled) or Nack, or once
+// the session is cancelled.
+func (ss *ServerSession) throttle() (err error) {
+	if ss.bucket == nil || ss.trans.Stopped() {
+		return
+	}
+
+	wait := ss.bucket.take()
+
+	if wait <= 0 {
+		return
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+	case <-ss.wake:
+	}
+
+	if ss.rejected() {
+		return ErrChunkRejected
+	}
+
+	return
+}
+
+// Interrupts any throttling of the session.
+func (ss *ServerSession) interrupt() {
+	select {
+	case ss.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (ss *ServerSession) read(e *transport.Entry) error {
+	if ss.src == nil {
+		return ss.trans.Next(ss.iter, e)
+	}
+
+	if ss.trans.Stopped() {
+		return io.EOF
+	}
+
+	return ss.src.Next(e)
+}
+
+// Whether the session is waiting for its next entry, and can be interrupted without losing
+// anything.
+func (ss *ServerSession) idle() bool {
+	if ss.src != nil {
+		return ss.src.Idle()
+	}
+
+	return ss.trans.Idle()
+}
+
+// Entries returns an iterator over the entries of the session, that ends along with the
+// session (e.g. on graceful shutdown). Any error ends the iteration, except ErrReadTimeout,
+// after which it goes on if the loop does. The entry is only valid until the next iteration,
+// and its record must be consumed.
+func (ss *ServerSession) Entries() iter.Seq2[*transport.Entry, error] {
+	return func(yield func(*transport.Entry, error) bool) {
+		var e transport.Entry
+
+		for {
+			if err := ss.Next(&e); err != nil {
+				if errors.Is(err, io.EOF) {
+					return
+				}
+
+				if !yield(nil, err) || !errors.Is(err, ErrReadTimeout) {
+					return
+				}
+
+				continue
+			}
+
+			if !yield(&e, nil) {
+				return
+			}
+		}
+	}
+}
+
+// Stops the session at the next chunk boundary. A session that is waiting for its next
+// entry is interrupted right away.
+func (ss *ServerSession) stop() {
+	ss.trans.Stop()
+	ss.interrupt()
+
+	if ss.idle() {
+		ss.conn.SetReadDeadline(time.Now())
+	}
 }
 
 func (ss *ServerSession) Close() error {
@@ -129,5 +344,10 @@ func (ss *ServerSession) Close() error {
 }
 
 func (ss *ServerSession) Rewind() {
+	if ss.src != nil {
+		ss.src.Rewind()
+		return
+	}
+
 	ss.trans.Rewind(ss.iter)
 }
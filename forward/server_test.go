@@ -0,0 +1,383 @@
+package forward
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/webmafia/fluentlog/forward/transport"
+	"github.com/webmafia/fluentlog/pkg/msgpack"
+)
+
+// Returns a chunk in Forward mode, with n entries.
+func testChunk(tag string, n int) []byte {
+	b := msgpack.AppendArrayHeader(nil, 2)
+	b = msgpack.AppendString(b, tag)
+	b = msgpack.AppendArrayHeader(b, n)
+
+	for i := range n {
+		b = msgpack.AppendArrayHeader(b, 2)
+		b = msgpack.AppendTimestamp(b, time.Unix(1700000000, 0))
+		b = msgpack.AppendMapHeader(b, 1)
+		b = msgpack.AppendString(b, "message")
+		b = msgpack.AppendString(b, fmt.Sprint(i))
+	}
+
+	return b
+}
+
+func TestServer_ShutdownDrainsChunk(t *testing.T) {
+	msgs := make(chan string, 10)
+	done := make(chan error, 1)
+
+	serv, addr := testServer(t, ServerOptions{Unauthenticated: true}, func(ctx context.Context, ss *ServerSession) error {
+		err := readMessages(ss, msgs)
+		done <- err
+		return err
+	})
+
+	// Handling is slow, so that the shutdown happens in the middle of the chunk
+	slow := make(chan string)
+
+	go func() {
+		for msg := range msgs {
+			time.Sleep(20 * time.Millisecond)
+			slow <- msg
+		}
+	}()
+
+	cli := NewClient(addr, ClientOptions{Unauthenticated: true})
+	defer cli.Close()
+
+	if _, err := cli.Write(testChunk("test", 5)); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := receive(t, slow); got != "0" {
+		t.Fatalf("expected first entry, got %q", got)
+	}
+
+	shutdown := make(chan error, 1)
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		shutdown <- serv.Shutdown(ctx)
+	}()
+
+	for i := 1; i < 5; i++ {
+		if got := receive(t, slow); got != fmt.Sprint(i) {
+			t.Fatalf("expected entry %d of the chunk, got %q", i, got)
+		}
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("expected the session to end without error, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("session didn't end")
+	}
+
+	if err := <-shutdown; err != nil {
+		t.Errorf("expected a graceful shutdown, got %v", err)
+	}
+}
+
+func TestServer_ShutdownDeadline(t *testing.T) {
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	defer close(release)
+
+	serv, addr := testServer(t, ServerOptions{Unauthenticated: true}, func(ctx context.Context, ss *ServerSession) error {
+		first := true
+
+		for _, err := range ss.Entries() {
+			if err != nil {
+				return err
+			}
+
+			// The handler is stuck in the middle of the chunk
+			if first {
+				first = false
+				started <- struct{}{}
+				<-release
+			}
+		}
+
+		return nil
+	})
+
+	cli := NewClient(addr, ClientOptions{Unauthenticated: true})
+	defer cli.Close()
+
+	if _, err := cli.Write(testChunk("test", 5)); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("session didn't start")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if err := serv.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+}
+
+func TestServer_ShutdownThrottled(t *testing.T) {
+	msgs := make(chan string, 10)
+
+	serv, addr := testServer(t, ServerOptions{
+		Unauthenticated: true,
+		RateLimit:       1,
+		RateBurst:       1,
+	}, func(ctx context.Context, ss *ServerSession) error {
+		return readMessages(ss, msgs)
+	})
+
+	cli := NewClient(addr, ClientOptions{Unauthenticated: true})
+	defer cli.Close()
+
+	if _, err := cli.Write(testChunk("test", 5)); err != nil {
+		t.Fatal(err)
+	}
+
+	receive(t, msgs)
+
+	// The rest of the chunk would take 4 seconds within the rate limit
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := serv.Shutdown(ctx); err != nil {
+		t.Fatalf("expected throttling to be interrupted, got %v", err)
+	}
+
+	for i := 1; i < 5; i++ {
+		receive(t, msgs)
+	}
+}
+
+func TestServer_RateLimitPerUser(t *testing.T) {
+	cred := Credentials{Username: "user", Password: "pass", SharedKey: "secret"}
+	msgs := make(chan string, 10)
+
+	serv, addr := testServer(t, ServerOptions{
+		Auth:             StaticAuthServer(cred),
+		PasswordAuth:     true,
+		RateLimit:        1000,
+		RateLimitPerUser: true,
+	}, func(ctx context.Context, ss *ServerSession) error {
+		return readMessages(ss, msgs)
+	})
+
+	buckets := func() int {
+		serv.mu.Lock()
+		defer serv.mu.Unlock()
+
+		return len(serv.buckets)
+	}
+
+	var clients []*Client
+
+	for range 2 {
+		cli := NewClient(addr, ClientOptions{Auth: StaticAuthClient(cred)})
+
+		if _, err := cli.Write(testEntry("test", "a")); err != nil {
+			t.Fatal(err)
+		}
+
+		receive(t, msgs)
+		clients = append(clients, cli)
+	}
+
+	if n := buckets(); n != 1 {
+		t.Errorf("expected sessions of the same user to share a bucket, got %d", n)
+	}
+
+	for _, cli := range clients {
+		cli.Close()
+	}
+
+	waitFor(t, "the bucket to be dropped", func() bool {
+		return buckets() == 0
+	})
+}
+
+// Returns a chunk in PackedForward mode with n entries, or in CompressedPackedForward mode
+// if compressed.
+func testPackedChunk(t *testing.T, tag string, n int, compressed bool) []byte {
+	t.Helper()
+
+	var entries []byte
+
+	for i := range n {
+		entries = msgpack.AppendArrayHeader(entries, 2)
+		entries = msgpack.AppendTimestamp(entries, time.Unix(1700000000, 0))
+		entries = msgpack.AppendMapHeader(entries, 1)
+		entries = msgpack.AppendString(entries, "message")
+		entries = msgpack.AppendString(entries, fmt.Sprint(i))
+	}
+
+	if !compressed {
+		b := msgpack.AppendArrayHeader(nil, 2)
+		b = msgpack.AppendString(b, tag)
+		return msgpack.AppendBinary(b, entries)
+	}
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+
+	if _, err := gz.Write(entries); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b := msgpack.AppendArrayHeader(nil, 3)
+	b = msgpack.AppendString(b, tag)
+	b = msgpack.AppendBinary(b, buf.Bytes())
+	b = msgpack.AppendMapHeader(b, 1)
+	b = msgpack.AppendString(b, "compressed")
+	return msgpack.AppendString(b, "gzip")
+}
+
+func receiveErr(t *testing.T, errs <-chan error) error {
+	t.Helper()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for an error")
+		return nil
+	}
+}
+
+func TestServer_MaxSessions(t *testing.T) {
+	tests := []struct {
+		name string
+		opt  ServerOptions
+	}{
+		{"MaxSessions", ServerOptions{MaxSessions: 1}},
+		{"MaxSessionsPerIP", ServerOptions{MaxSessionsPerIP: 1, Address: testTcpAddr(t)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msgs := make(chan string, 10)
+			errs := make(chan error, 10)
+
+			tt.opt.Unauthenticated = true
+			tt.opt.HandleError = func(err error) { errs <- err }
+
+			_, addr := testServer(t, tt.opt, func(ctx context.Context, ss *ServerSession) error {
+				return readMessages(ss, msgs)
+			})
+
+			first := NewClient(addr, ClientOptions{Unauthenticated: true})
+			defer first.Close()
+
+			if _, err := first.Write(testEntry("test", "a")); err != nil {
+				t.Fatal(err)
+			}
+
+			receive(t, msgs)
+
+			second := NewClient(addr, ClientOptions{Unauthenticated: true})
+			defer second.Close()
+
+			if err := second.Connect(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := receiveErr(t, errs); !errors.Is(err, ErrTooManySessions) {
+				t.Errorf("expected ErrTooManySessions, got %v", err)
+			}
+		})
+	}
+}
+
+func TestServer_SizeLimits(t *testing.T) {
+	tests := []struct {
+		name       string
+		opt        ServerOptions
+		compressed bool
+		err        error
+	}{
+		{"MaxMessageSize", ServerOptions{MaxMessageSize: 100}, false, transport.ErrMessageTooLarge},
+		{"MaxDecompressedSize", ServerOptions{MaxDecompressedSize: 100}, true, transport.ErrDecompressedTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msgs := make(chan string, 100)
+			errs := make(chan error, 10)
+
+			tt.opt.Unauthenticated = true
+			tt.opt.HandleError = func(err error) { errs <- err }
+
+			_, addr := testServer(t, tt.opt, func(ctx context.Context, ss *ServerSession) error {
+				return readMessages(ss, msgs)
+			})
+
+			cli := NewClient(addr, ClientOptions{Unauthenticated: true})
+			defer cli.Close()
+
+			// A chunk within the limit is accepted
+			if _, err := cli.Write(testPackedChunk(t, "test", 1, tt.compressed)); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := receive(t, msgs); got != "0" {
+				t.Fatalf("expected the small chunk, got %q", got)
+			}
+
+			if _, err := cli.Write(testPackedChunk(t, "test", 20, tt.compressed)); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := receiveErr(t, errs); !errors.Is(err, tt.err) {
+				t.Errorf("expected %v, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestServer_IdleTimeout(t *testing.T) {
+	msgs := make(chan string, 10)
+	errs := make(chan error, 10)
+
+	_, addr := testServer(t, ServerOptions{
+		Unauthenticated: true,
+		IdleTimeout:     50 * time.Millisecond,
+		HandleError:     func(err error) { errs <- err },
+	}, func(ctx context.Context, ss *ServerSession) error {
+		return readMessages(ss, msgs)
+	})
+
+	cli := NewClient(addr, ClientOptions{Unauthenticated: true})
+	defer cli.Close()
+
+	if _, err := cli.Write(testEntry("test", "a")); err != nil {
+		t.Fatal(err)
+	}
+
+	receive(t, msgs)
+
+	if err := receiveErr(t, errs); !errors.Is(err, ErrIdleTimeout) {
+		t.Errorf("expected ErrIdleTimeout, got %v", err)
+	}
+}
@@ -0,0 +1,338 @@
+package forward
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"slices"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/webmafia/fast"
+	"github.com/webmafia/fluentlog/forward/transport"
+	"github.com/webmafia/fluentlog/pkg/msgpack"
+)
+
+// A syslog server receives RFC 3164 and RFC 5424 messages over TCP (with either octet-counting
+// or newline framing, as per RFC 6587) and UDP, and passes them to the same handlers as Server.
+// Each message is an entry tagged "<tag>.<facility>.<severity>" (e.g. "syslog.daemon.err"),
+// whose record has the keys "pri" (the severity as a fluentlog.Severity), "facility", "host",
+// "ident", "pid", "msgid" and "message", along with any structured data as nested maps by
+// SD-ID. Syslog has no acknowledgements, and its entries have no chunk. UDP is served by a
+// single session, whose RemoteAddr is the sender of the last read message.
+type SyslogServer struct {
+	serv *Server
+	opt  SyslogOptions
+}
+
+type SyslogOptions struct {
+	Address        string      // TCP and UDP address ("host:port"), or Unix domain socket ("unix:///path/to/socket").
+	Tls            *tls.Config // RFC 5425, which disables UDP.
+	Tag            string      // Prefix of the tags. Defaults to "syslog".
+	HandleError    func(err error)
+	ReadTimeout    time.Duration // Max time to wait for data. Next then fails with ErrReadTimeout and can be retried.
+	MaxMessageSize int           // Max size in bytes of a message. Defaults to 64 KiB, and larger UDP messages are truncated.
+
+	// Limits of TCP connections, where zero means unlimited. UDP is served by a single session.
+	MaxSessions      int
+	MaxSessionsPerIP int
+}
+
+func (opt *SyslogOptions) setDefaults() {
+	if opt.Tag == "" {
+		opt.Tag = "syslog"
+	}
+
+	if opt.MaxMessageSize <= 0 {
+		opt.MaxMessageSize = 64 * 1024
+	}
+}
+
+func NewSyslogServer(opt SyslogOptions) *SyslogServer {
+	opt.setDefaults()
+
+	return &SyslogServer{
+		serv: NewServer(ServerOptions{
+			Address:          opt.Address,
+			Tls:              opt.Tls,
+			HandleError:      opt.HandleError,
+			ReadTimeout:      opt.ReadTimeout,
+			Unauthenticated:  true,
+			MaxSessions:      opt.MaxSessions,
+			MaxSessionsPerIP: opt.MaxSessionsPerIP,
+			MaxMessageSize:   opt.MaxMessageSize,
+		}),
+		opt: opt,
+	}
+}
+
+// Listens for messages until either ctx is cancelled, or the server is shut down (in which
+// case ErrServerClosed is returned). Sessions are cancelled along with ctx.
+func (s *SyslogServer) Listen(ctx context.Context, handler func(ctx context.Context, ss *ServerSession) error) (err error) {
+	sessCtx := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if err = s.serv.start(cancel); err != nil {
+		return
+	}
+
+	listener, err := s.serv.listen(ctx)
+
+	if err != nil {
+		return
+	}
+
+	var udp *syslogUDPConn
+
+	if network, addr := splitNetwork(s.opt.Address); network == "tcp" && s.opt.Tls == nil {
+		if udp, err = s.listenUDP(ctx, addr); err != nil {
+			listener.Close()
+			return
+		}
+	}
+
+	go func() {
+		<-ctx.Done()
+
+		if udp != nil {
+			udp.Close()
+		}
+
+		listener.Close()
+		log.Println("Closed syslog server")
+	}()
+
+	source := func(conn net.Conn) entrySource {
+		return newSyslogReader(conn, &s.opt)
+	}
+
+	ready := func(_ context.Context, _ *ServerSession) error { return nil }
+
+	if udp != nil {
+		ip, err := s.serv.acquireSession(udp)
+
+		if err != nil {
+			listener.Close()
+			udp.Close()
+			return err
+		}
+
+		go s.serv.serve(sessCtx, udp, ip, source(udp), handler, ready)
+	}
+
+	log.Println("Listening for syslog on", s.opt.Address)
+
+	return s.serv.accept(sessCtx, listener, source, handler, ready)
+}
+
+func (s *SyslogServer) listenUDP(ctx context.Context, addr string) (conn *syslogUDPConn, err error) {
+	var lc net.ListenConfig
+
+	pc, err := lc.ListenPacket(ctx, "udp", addr)
+
+	if err != nil {
+		return
+	}
+
+	conn = &syslogUDPConn{UDPConn: pc.(*net.UDPConn)}
+	conn.sender.Store(&net.UDPAddr{})
+	return
+}
+
+// A UDP connection, whose remote address is the sender of the last read datagram.
+type syslogUDPConn struct {
+	*net.UDPConn
+	sender atomic.Pointer[net.UDPAddr]
+}
+
+func (c *syslogUDPConn) Read(b []byte) (n int, err error) {
+	n, addr, err := c.ReadFromUDP(b)
+
+	if err == nil {
+		c.sender.Store(addr)
+	}
+
+	return
+}
+
+func (c *syslogUDPConn) RemoteAddr() net.Addr {
+	return c.sender.Load()
+}
+
+// Shutdown gracefully shuts down the server, just like Server.Shutdown.
+func (s *SyslogServer) Shutdown(ctx context.Context) error {
+	return s.serv.Shutdown(ctx)
+}
+
+// Reads syslog messages of a session as entries.
+type syslogReader struct {
+	conn    net.Conn
+	r       *bufio.Reader // Nil for UDP, where each datagram is a message
+	tag     string
+	maxSize int
+	buf     []byte // Current message
+	tagBuf  []byte
+	rec     []byte
+	iter    msgpack.Iterator
+	msg     syslogMessage
+	idle    atomic.Bool // Waiting for the next message
+}
+
+func newSyslogReader(conn net.Conn, opt *SyslogOptions) *syslogReader {
+	r := &syslogReader{
+		conn:    conn,
+		tag:     opt.Tag,
+		maxSize: opt.MaxMessageSize,
+		iter:    msgpack.NewIterator(nil),
+	}
+
+	if _, ok := conn.(*syslogUDPConn); ok {
+		r.buf = make([]byte, r.maxSize)
+	} else {
+		r.r = bufio.NewReader(conn)
+	}
+
+	return r
+}
+
+func (r *syslogReader) Next(e *transport.Entry) (err error) {
+	if err = r.read(); err != nil {
+		return
+	}
+
+	r.msg.parse(r.buf, time.Now())
+
+	r.tagBuf = r.msg.appendTag(r.tagBuf[:0], r.tag)
+	r.rec = r.msg.appendRecord(r.rec[:0])
+	r.Rewind()
+
+	*e = transport.Entry{
+		Tag:       fast.BytesToString(r.tagBuf),
+		Timestamp: r.msg.time,
+		Record:    &r.iter,
+	}
+
+	return
+}
+
+// Rewinds the record of the current entry.
+func (r *syslogReader) Rewind() {
+	r.iter.ResetBytes(r.rec)
+	r.iter.Next()
+}
+
+func (r *syslogReader) Idle() bool {
+	return r.idle.Load()
+}
+
+// Reads the next message into buf.
+func (r *syslogReader) read() (err error) {
+	r.idle.Store(true)
+	defer r.idle.Store(false)
+
+	if r.r == nil {
+		return r.readDatagram()
+	}
+
+	var c byte
+
+	// Skip any trailing line breaks of the previous message
+	for {
+		if c, err = r.r.ReadByte(); err != nil {
+			return
+		}
+
+		if c != '\n' && c != '\r' && c != 0 {
+			break
+		}
+	}
+
+	r.idle.Store(false)
+	r.r.UnreadByte()
+
+	// Octet-counted messages start with their length, and others with their PRI
+	if c >= '1' && c <= '9' {
+		return r.readOctetCounted()
+	}
+
+	return r.readLine()
+}
+
+func (r *syslogReader) readDatagram() error {
+	for {
+		n, err := r.conn.Read(r.buf[:cap(r.buf)])
+
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				err = io.EOF
+			}
+
+			return err
+		}
+
+		if n > 0 {
+			r.buf = r.buf[:n]
+			return nil
+		}
+	}
+}
+
+func (r *syslogReader) readOctetCounted() (err error) {
+	head, err := r.r.ReadSlice(' ')
+
+	if err != nil {
+		return noEOF(err)
+	}
+
+	n, err := strconv.Atoi(fast.BytesToString(head[:len(head)-1]))
+
+	if err != nil {
+		return fmt.Errorf("%w: invalid length %q", ErrInvalidSyslog, head)
+	}
+
+	if n > r.maxSize {
+		return fmt.Errorf("%w: %d bytes, max %d", transport.ErrMessageTooLarge, n, r.maxSize)
+	}
+
+	r.buf = slices.Grow(r.buf[:0], n)[:n]
+	_, err = io.ReadFull(r.r, r.buf)
+	return noEOF(err)
+}
+
+func (r *syslogReader) readLine() error {
+	r.buf = r.buf[:0]
+
+	for {
+		line, err := r.r.ReadSlice('\n')
+
+		if len(r.buf)+len(line) > r.maxSize {
+			return fmt.Errorf("%w: more than %d bytes", transport.ErrMessageTooLarge, r.maxSize)
+		}
+
+		r.buf = append(r.buf, line...)
+
+		if err != bufio.ErrBufferFull {
+			// The last message might not be terminated
+			if err == io.EOF && len(r.buf) > 0 {
+				err = nil
+			}
+
+			return err
+		}
+	}
+}
+
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+
+	return err
+}
@@ -0,0 +1,358 @@
+package forward
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/webmafia/fast"
+	"github.com/webmafia/fluentlog"
+	"github.com/webmafia/fluentlog/pkg/msgpack"
+)
+
+// Facility names, as used by Fluentd.
+var syslogFacilities = [...]string{
+	"kern",
+	"user",
+	"mail",
+	"daemon",
+	"auth",
+	"syslog",
+	"lpr",
+	"news",
+	"uucp",
+	"cron",
+	"authpriv",
+	"ftp",
+	"ntp",
+	"audit",
+	"alert",
+	"at",
+	"local0",
+	"local1",
+	"local2",
+	"local3",
+	"local4",
+	"local5",
+	"local6",
+	"local7",
+}
+
+// Keys of the record, that structured data can't use as SD-ID.
+var syslogKeys = [...]string{"pri", "facility", "host", "ident", "pid", "msgid", "message"}
+
+// A syslog message, parsed from either RFC 3164 or RFC 5424. Strings refer to the parsed
+// data, and are only valid until it's modified.
+type syslogMessage struct {
+	facility uint8
+	severity fluentlog.Severity
+	time     time.Time
+	host     string
+	ident    string // Tag of RFC 3164, or APP-NAME of RFC 5424
+	pid      string
+	msgId    string
+	data     []sdElement
+	params   []sdParam
+	message  string
+}
+
+// An SD-ELEMENT of RFC 5424, whose params are params[from:to] of the message.
+type sdElement struct {
+	id       string
+	from, to int
+}
+
+type sdParam struct {
+	name, value string
+}
+
+// Parses a message, which is never rejected. Any part that can't be parsed is kept as
+// part of the message.
+func (m *syslogMessage) parse(b []byte, now time.Time) {
+	*m = syslogMessage{
+		facility: 1, // user.notice, as per RFC 3164 for messages without PRI
+		severity: fluentlog.NOTICE,
+		data:     m.data[:0],
+		params:   m.params[:0],
+	}
+
+	s := m.parsePri(strings.TrimRight(fast.BytesToString(b), "\r\n\x00"))
+
+	if rest, ok := strings.CutPrefix(s, "1 "); ok {
+		m.parse5424(rest, now)
+	} else {
+		m.parse3164(s, now)
+	}
+}
+
+func (m *syslogMessage) parsePri(s string) string {
+	if len(s) < 3 || s[0] != '<' {
+		return s
+	}
+
+	end := strings.IndexByte(s[:min(len(s), 5)], '>')
+
+	if end < 2 {
+		return s
+	}
+
+	pri, err := strconv.ParseUint(s[1:end], 10, 8)
+
+	if err != nil || pri > 191 {
+		return s
+	}
+
+	m.facility, m.severity = uint8(pri>>3), fluentlog.Severity(pri&7)
+	return s[end+1:]
+}
+
+// Parses "TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA [MSG]".
+func (m *syslogMessage) parse5424(s string, now time.Time) {
+	var ts string
+
+	ts, s = nextSyslogField(s)
+	m.host, s = nextSyslogField(s)
+	m.ident, s = nextSyslogField(s)
+	m.pid, s = nextSyslogField(s)
+	m.msgId, s = nextSyslogField(s)
+
+	if t, err := time.Parse(time.RFC3339Nano, ts); err == nil {
+		m.time = t
+	} else {
+		m.time = now
+	}
+
+	m.message = strings.TrimPrefix(m.parseData(s), "\uFEFF") // Byte order mark
+}
+
+// Returns the next space-separated field, where "-" is empty.
+func nextSyslogField(s string) (field, rest string) {
+	if field, rest, _ = strings.Cut(s, " "); field == "-" {
+		field = ""
+	}
+
+	return
+}
+
+// Parses any structured data, and returns the rest of the message.
+func (m *syslogMessage) parseData(s string) string {
+	if rest, ok := strings.CutPrefix(s, "-"); ok {
+		return strings.TrimPrefix(rest, " ")
+	}
+
+	rest := s
+
+	for len(rest) > 0 && rest[0] == '[' {
+		var ok bool
+
+		if rest, ok = m.parseElement(rest[1:]); !ok {
+			m.data, m.params = m.data[:0], m.params[:0]
+			return s
+		}
+	}
+
+	return strings.TrimPrefix(rest, " ")
+}
+
+// Parses an SD-ELEMENT after its "[", and returns the rest after its "]".
+func (m *syslogMessage) parseElement(s string) (rest string, ok bool) {
+	end := strings.IndexAny(s, " ]")
+
+	if end <= 0 {
+		return "", false
+	}
+
+	el := sdElement{id: s[:end], from: len(m.params)}
+	s = s[end:]
+
+	for len(s) > 0 && s[0] == ' ' {
+		name, value, found := strings.Cut(s[1:], "=\"")
+
+		if !found || name == "" {
+			return "", false
+		}
+
+		if value, s, ok = parseParamValue(value); !ok {
+			return "", false
+		}
+
+		m.params = append(m.params, sdParam{name: name, value: value})
+	}
+
+	if len(s) == 0 || s[0] != ']' {
+		return "", false
+	}
+
+	el.to = len(m.params)
+
+	if !slices.Contains(syslogKeys[:], el.id) {
+		m.data = append(m.data, el)
+	}
+
+	return s[1:], true
+}
+
+// Parses a PARAM-VALUE after its opening quote, and returns the rest after its closing quote.
+func parseParamValue(s string) (value, rest string, ok bool) {
+	escaped := false
+
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+
+		case '\\':
+			escaped = true
+			i++
+
+		case '"':
+			if value = s[:i]; escaped {
+				value = unescapeParamValue(value)
+			}
+
+			return value, s[i+1:], true
+
+		}
+	}
+
+	return
+}
+
+func unescapeParamValue(s string) string {
+	b := make([]byte, 0, len(s))
+
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			switch s[i+1] {
+			case '"', '\\', ']':
+				i++
+			}
+		}
+
+		b = append(b, s[i])
+	}
+
+	return fast.BytesToString(b)
+}
+
+// Parses "TIMESTAMP HOSTNAME TAG[PID]: MSG", where anything but the message is optional.
+func (m *syslogMessage) parse3164(s string, now time.Time) {
+	var ok bool
+
+	if m.time, s, ok = parseSyslogStamp(s, now); ok {
+		// The hostname is left out by e.g. local sockets
+		if host, rest, _ := strings.Cut(s, " "); !strings.HasSuffix(host, ":") && !strings.Contains(host, "[") {
+			m.host, s = host, rest
+		}
+	}
+
+	m.message = m.parseTag(s)
+}
+
+// Parses a timestamp of either RFC 3164 (e.g. "Oct  5 12:00:00", in local time of the
+// current year), or RFC 3339 (as sent by e.g. Go's log/syslog and rsyslog).
+func parseSyslogStamp(s string, now time.Time) (t time.Time, rest string, ok bool) {
+	if n := len(time.Stamp); len(s) > n && s[n] == ' ' {
+		if t, err := time.ParseInLocation(time.Stamp, s[:n], now.Location()); err == nil {
+			t = time.Date(now.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, t.Location())
+
+			// E.g. a message of December 31st that is received on January 1st
+			if t.After(now.AddDate(0, 0, 1)) {
+				t = t.AddDate(-1, 0, 0)
+			}
+
+			return t, s[n+1:], true
+		}
+	}
+
+	field, rest, _ := strings.Cut(s, " ")
+
+	if t, err := time.Parse(time.RFC3339Nano, field); err == nil {
+		return t, rest, true
+	}
+
+	return now, s, false
+}
+
+// Parses any "TAG:" or "TAG[PID]:", and returns the rest of the message.
+func (m *syslogMessage) parseTag(s string) string {
+	end := strings.IndexAny(s, ":[ ")
+
+	if end <= 0 || s[end] == ' ' {
+		return s
+	}
+
+	rest := s[end+1:]
+
+	if s[end] == '[' {
+		pid, after, ok := strings.Cut(rest, "]")
+
+		if !ok || !strings.HasPrefix(after, ":") {
+			return s
+		}
+
+		m.pid, rest = pid, after[1:]
+	}
+
+	m.ident = s[:end]
+	return strings.TrimPrefix(rest, " ")
+}
+
+// Appends the message as a record, with structured data as nested maps by SD-ID.
+func (m *syslogMessage) appendRecord(dst []byte) []byte {
+	n := 3 + len(m.data)
+
+	for _, s := range [...]string{m.host, m.ident, m.pid, m.msgId} {
+		if s != "" {
+			n++
+		}
+	}
+
+	dst = msgpack.AppendMapHeader(dst, n)
+	dst = msgpack.AppendString(dst, "pri")
+	dst = msgpack.AppendUint(dst, uint64(m.severity))
+	dst = msgpack.AppendString(dst, "facility")
+	dst = msgpack.AppendString(dst, m.facilityName())
+	dst = appendSyslogField(dst, "host", m.host)
+	dst = appendSyslogField(dst, "ident", m.ident)
+	dst = appendSyslogField(dst, "pid", m.pid)
+	dst = appendSyslogField(dst, "msgid", m.msgId)
+
+	for _, el := range m.data {
+		dst = msgpack.AppendString(dst, el.id)
+		dst = msgpack.AppendMapHeader(dst, el.to-el.from)
+
+		for _, p := range m.params[el.from:el.to] {
+			dst = msgpack.AppendString(dst, p.name)
+			dst = msgpack.AppendString(dst, p.value)
+		}
+	}
+
+	dst = msgpack.AppendString(dst, "message")
+	return msgpack.AppendString(dst, m.message)
+}
+
+func appendSyslogField(dst []byte, key, val string) []byte {
+	if val == "" {
+		return dst
+	}
+
+	dst = msgpack.AppendString(dst, key)
+	return msgpack.AppendString(dst, val)
+}
+
+func (m *syslogMessage) facilityName() string {
+	if int(m.facility) >= len(syslogFacilities) {
+		return strconv.Itoa(int(m.facility))
+	}
+
+	return syslogFacilities[m.facility]
+}
+
+// Appends the tag of the message, e.g. "syslog.daemon.err" with prefix "syslog".
+func (m *syslogMessage) appendTag(dst []byte, prefix string) []byte {
+	dst = append(dst, prefix...)
+	dst = append(dst, '.')
+	dst = append(dst, m.facilityName()...)
+	dst = append(dst, '.')
+	return append(dst, m.severity.String()...)
+}
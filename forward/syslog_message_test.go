@@ -0,0 +1,79 @@
+package forward
+
+import (
+	"testing"
+	"time"
+
+	"github.com/webmafia/fluentlog"
+	"github.com/webmafia/fluentlog/pkg/msgpack"
+)
+
+func TestSyslogMessage(t *testing.T) {
+	now := time.Date(2026, 1, 2, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		msg      string
+		facility string
+		severity fluentlog.Severity
+		time     time.Time
+		host     string
+		ident    string
+		pid      string
+		message  string
+	}{
+		{"<34>Oct 11 22:14:15 mymachine su: 'su root' failed", "auth", fluentlog.CRIT, time.Date(2025, 10, 11, 22, 14, 15, 0, time.UTC), "mymachine", "su", "", "'su root' failed"},
+		{"<13>Jan  2 11:59:00 host app[123]: hello\n", "user", fluentlog.NOTICE, time.Date(2026, 1, 2, 11, 59, 0, 0, time.UTC), "host", "app", "123", "hello"},
+		{"<30>2026-01-02T11:00:00Z host foo.bar[42]: waazzaaaaa", "daemon", fluentlog.INFO, time.Date(2026, 1, 2, 11, 0, 0, 0, time.UTC), "host", "foo.bar", "42", "waazzaaaaa"},
+		{"<14>Jan  2 11:59:00 cron: no host", "user", fluentlog.INFO, time.Date(2026, 1, 2, 11, 59, 0, 0, time.UTC), "", "cron", "", "no host"},
+		{"just a message", "user", fluentlog.NOTICE, now, "", "", "", "just a message"},
+		{"<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog - ID47 - \uFEFFAn application event", "local4", fluentlog.NOTICE, time.Date(2003, 10, 11, 22, 14, 15, 3e6, time.UTC), "mymachine.example.com", "evntslog", "", "An application event"},
+		{"<11>1 - - - - - - ", "user", fluentlog.ERR, now, "", "", "", ""},
+		{"<11>1 - - - - - [broken", "user", fluentlog.ERR, now, "", "", "", "[broken"},
+	}
+
+	var m syslogMessage
+
+	for _, tt := range tests {
+		m.parse([]byte(tt.msg), now)
+
+		if m.facilityName() != tt.facility || m.severity != tt.severity || !m.time.Equal(tt.time) {
+			t.Errorf("%q: got %s.%s at %s", tt.msg, m.facilityName(), m.severity, m.time)
+		}
+
+		if m.host != tt.host || m.ident != tt.ident || m.pid != tt.pid || m.message != tt.message {
+			t.Errorf("%q: got host %q, ident %q, pid %q, message %q", tt.msg, m.host, m.ident, m.pid, m.message)
+		}
+	}
+}
+
+func TestSyslogStructuredData(t *testing.T) {
+	msg := `<165>1 2003-10-11T22:14:15.003Z host app 1234 ID47 [exampleSDID@32473 iut="3" eventSource="App\"lication"][origin ip="192.0.2.1"] hello`
+
+	var m syslogMessage
+	m.parse([]byte(msg), time.Now())
+
+	rec := msgpack.Value(m.appendRecord(nil))
+
+	if s, _ := rec.GetStr("exampleSDID@32473", "eventSource"); s != `App"lication` {
+		t.Errorf("unexpected escaped param: %q", s)
+	}
+
+	if s, _ := rec.GetStr("origin", "ip"); s != "192.0.2.1" {
+		t.Errorf("unexpected param: %q", s)
+	}
+
+	if s, _ := rec.GetStr("message"); s != "hello" {
+		t.Errorf("unexpected message: %q", s)
+	}
+
+	if s, _ := rec.GetStr("msgid"); s != "ID47" {
+		t.Errorf("unexpected msgid: %q", s)
+	}
+
+	var r LogRecord
+	r.decode("syslog.local4.notice", m.time, rec)
+
+	if r.Severity != fluentlog.NOTICE || r.Message != "hello" || r.Fluentlog {
+		t.Errorf("unexpected log record: %+v", r)
+	}
+}
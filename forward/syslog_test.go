@@ -0,0 +1,209 @@
+package forward
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/webmafia/fluentlog/forward/transport"
+)
+
+// A message received by a syslog server, along with its sender.
+type syslogReceived struct {
+	message string
+	addr    string
+}
+
+// Starts a syslog server on a free TCP and UDP port of localhost, that is shut down when the
+// test ends. Received messages are sent to msgs, and any session error to errs.
+func testSyslog(t *testing.T, opt SyslogOptions) (addr string, msgs chan syslogReceived, errs chan error) {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opt.Address = l.Addr().String()
+	l.Close()
+
+	msgs = make(chan syslogReceived, 10)
+	errs = make(chan error, 10)
+	serv := NewSyslogServer(opt)
+	done := make(chan error, 1)
+
+	go func() {
+		done <- serv.Listen(context.Background(), func(ctx context.Context, ss *ServerSession) error {
+			for e, err := range ss.Entries() {
+				if err != nil {
+					errs <- err
+					return err
+				}
+
+				o, err := e.Clone()
+
+				if err != nil {
+					return err
+				}
+
+				msgs <- syslogReceived{
+					message: o.Record.Get("message").Str(),
+					addr:    ss.RemoteAddr().String(),
+				}
+			}
+
+			return nil
+		})
+	}()
+
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		serv.Shutdown(ctx)
+		<-done
+	})
+
+	waitFor(t, "syslog server to listen", func() bool {
+		conn, err := net.Dial("tcp", opt.Address)
+
+		if err != nil {
+			return false
+		}
+
+		conn.Close()
+		return true
+	})
+
+	return opt.Address, msgs, errs
+}
+
+func receiveSyslog(t *testing.T, msgs <-chan syslogReceived) syslogReceived {
+	t.Helper()
+
+	select {
+	case msg := <-msgs:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a syslog message")
+		return syslogReceived{}
+	}
+}
+
+func TestSyslogServer_Framing(t *testing.T) {
+	addr, msgs, _ := testSyslog(t, SyslogOptions{})
+
+	conn, err := net.Dial("tcp", addr)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer conn.Close()
+
+	octetCounted := func(msg string) string {
+		return fmt.Sprintf("%d %s", len(msg), msg)
+	}
+
+	// Octet-counted messages may contain line breaks, and may be mixed with newline framing
+	frames := octetCounted("<13>Jan  2 11:59:00 host a: one") +
+		"<13>Jan  2 11:59:00 host a: two\n" +
+		octetCounted("<13>Jan  2 11:59:00 host a: three\nfour") +
+		"<13>Jan  2 11:59:00 host a: five\r\n\n"
+
+	if _, err = conn.Write([]byte(frames)); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, msg := range []string{"one", "two", "three\nfour", "five"} {
+		if got := receiveSyslog(t, msgs); strings.TrimRight(got.message, "\r\n") != msg {
+			t.Errorf("expected %q, got %q", msg, got.message)
+		}
+	}
+}
+
+func TestSyslogServer_MaxMessageSize(t *testing.T) {
+	tests := []struct {
+		name  string
+		frame string
+	}{
+		{"OctetCounted", "100 <13>Jan  2 11:59:00 host a: " + strings.Repeat("x", 68)},
+		{"Newline", "<13>Jan  2 11:59:00 host a: " + strings.Repeat("x", 100) + "\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr, msgs, errs := testSyslog(t, SyslogOptions{MaxMessageSize: 64})
+
+			conn, err := net.Dial("tcp", addr)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			defer conn.Close()
+
+			if _, err = conn.Write([]byte("<13>Jan  2 11:59:00 host a: fits\n" + tt.frame)); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := receiveSyslog(t, msgs); strings.TrimSpace(got.message) != "fits" {
+				t.Errorf("expected \"fits\", got %q", got.message)
+			}
+
+			select {
+			case err := <-errs:
+				if !errors.Is(err, transport.ErrMessageTooLarge) {
+					t.Errorf("expected ErrMessageTooLarge, got %v", err)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("timed out waiting for the session to fail")
+			}
+		})
+	}
+}
+
+func TestSyslogServer_UDP(t *testing.T) {
+	const head = "<13>Jan  2 11:59:00 host a: "
+
+	addr, msgs, _ := testSyslog(t, SyslogOptions{MaxMessageSize: len(head) + 8})
+
+	tests := []struct {
+		sent     string
+		received string
+	}{
+		{"first", "first"},
+		{"second", "second"},
+		{"truncated message", "truncate"},
+	}
+
+	for _, tt := range tests {
+		conn, err := net.Dial("udp", addr)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer conn.Close()
+
+		if _, err = conn.Write([]byte(head + tt.sent)); err != nil {
+			t.Fatal(err)
+		}
+
+		got := receiveSyslog(t, msgs)
+
+		if got.message != tt.received {
+			t.Errorf("expected %q, got %q", tt.received, got.message)
+		}
+
+		// Each message is from another sender
+		if got.addr != conn.LocalAddr().String() {
+			t.Errorf("expected the sender %s, got %s", conn.LocalAddr(), got.addr)
+		}
+	}
+}
@@ -7,7 +7,9 @@ import (
 	"testing"
 	"time"
 
+	"github.com/klauspost/compress/zstd"
 	"github.com/webmafia/fluentlog/internal/gzip"
+	internalZstd "github.com/webmafia/fluentlog/internal/zstd"
 	"github.com/webmafia/fluentlog/pkg/msgpack"
 )
 
@@ -79,6 +81,24 @@ func mockCompressedPackedForwardMode(msg []byte, count int) []byte {
 	return buf
 }
 
+func mockZstdCompressedPackedForwardMode(msg []byte, count int) []byte {
+	var w bytes.Buffer
+	wr, _ := zstd.NewWriter(&w)
+
+	for range count {
+		wr.Write(msg)
+	}
+
+	wr.Close()
+
+	var buf []byte
+	buf = msgpack.AppendArrayHeader(buf, 2)
+	buf = msgpack.AppendString(buf, "tag")
+	buf = msgpack.AppendBinary(buf, w.Bytes())
+
+	return buf
+}
+
 func mockMsg() (msg []byte) {
 	msg = msgpack.AppendArrayHeader(msg, 2)
 	msg = msgpack.AppendTimestamp(msg, time.Now())
@@ -113,15 +133,22 @@ func BenchmarkCompressedPackedForwardMode(b *testing.B) {
 	bench(b, mockCompressedPackedForwardMode(msg, b.N))
 }
 
+func BenchmarkZstdCompressedPackedForwardMode(b *testing.B) {
+	msg := mockMsg()
+	b.SetBytes(int64(len(msg)))
+	bench(b, mockZstdCompressedPackedForwardMode(msg, b.N))
+}
+
 func bench(b *testing.B, data []byte) {
 	var (
 		t        TransportPhase
 		iterPool msgpack.IterPool
 		gzipPool gzip.Pool
+		zstdPool internalZstd.Pool
 	)
 
 	iter := msgpack.NewIterator(bytes.NewReader(data))
-	t.Init(&iterPool, &gzipPool, func(chunk string) error { return nil })
+	t.Init(&iterPool, &gzipPool, &zstdPool, func(_ *Chunk) error { return nil })
 	b.ResetTimer()
 
 	// 4) We'll read b.N sub-events, ignoring them but measuring parse overhead
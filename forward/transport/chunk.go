@@ -0,0 +1,80 @@
+package transport
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/webmafia/fluentlog/pkg/msgpack"
+)
+
+var ErrSizeMismatch = errors.New("declared size doesn't match the number of entries")
+
+// Transport mode of a chunk.
+type ChunkMode uint8
+
+const (
+	ModeMessage ChunkMode = iota
+	ModeForward
+	ModePackedForward
+	ModeCompressedPackedForward
+)
+
+var chunkModeStrings = [...]string{
+	"Message",
+	"Forward",
+	"PackedForward",
+	"CompressedPackedForward",
+}
+
+func (m ChunkMode) String() string {
+	if int(m) >= len(chunkModeStrings) {
+		return fmt.Sprintf("(invalid mode %d)", m)
+	}
+
+	return chunkModeStrings[m]
+}
+
+// A chunk, i.e. a message of the Forward protocol, containing one or more entries. The chunk
+// is owned by the transport phase, and only valid until the next chunk is read. As the options
+// of a chunk are sent after its entries, ID and Size are only known once it has been read.
+type Chunk struct {
+	Seq         uint64    // Sequence number within the session, starting at 1
+	Mode        ChunkMode // Transport mode
+	Compression Codec     // Compression of the entries
+	Entries     int       // Number of entries read so far
+	ID          string    // Value of the "chunk" option, if any
+	Size        int       // Value of the "size" option, or zero if not declared
+}
+
+// Starts a new chunk.
+func (t *TransportPhase) beginChunk() {
+	t.chunk = Chunk{
+		Seq: t.seq.Add(1),
+	}
+}
+
+// Sets the chunk metadata of an entry, and counts it.
+func (t *TransportPhase) chunkEntry(e *Entry) {
+	e.Chunk = &t.chunk
+	e.Index = t.chunk.Entries
+	t.chunk.Entries++
+}
+
+// Ends the current chunk by reading its options (if any), and passes it to the callback.
+func (t *TransportPhase) endChunk(iter *msgpack.Iterator, hasOptions bool) (err error) {
+	if hasOptions {
+		if err = t.handleOptions(iter); err != nil {
+			return
+		}
+	}
+
+	if t.chunk.Size > 0 && t.chunk.Size != t.chunk.Entries {
+		return t.error("size", fmt.Errorf("%w: declared %d, got %d", ErrSizeMismatch, t.chunk.Size, t.chunk.Entries))
+	}
+
+	if err = t.done(&t.chunk); err != nil {
+		return t.error("chunk", err)
+	}
+
+	return
+}
@@ -7,6 +7,7 @@ import (
 
 	"github.com/webmafia/fast/ringbuf"
 	"github.com/webmafia/fluentlog/internal/gzip"
+	"github.com/webmafia/fluentlog/internal/zstd"
 	"github.com/webmafia/fluentlog/pkg/msgpack"
 	"github.com/webmafia/fluentlog/pkg/msgpack/types"
 )
@@ -17,6 +18,8 @@ type CompressedPackedForwardMode struct {
 	t          *TransportPhase
 	iter       *msgpack.Iterator
 	gzip       *gzip.Reader
+	zstd       *zstd.Decoder
+	limiter    sizeLimiter
 	tag        string
 	hasOptions bool
 }
@@ -31,14 +34,41 @@ func (m *CompressedPackedForwardMode) String() string {
 	return "CompressedPackedForwardMode"
 }
 
-func (m *CompressedPackedForwardMode) Enter(origIter *msgpack.Iterator, e *Entry, br ringbuf.RingBufferReader, hasOptions bool) (err error) {
+func (m *CompressedPackedForwardMode) Enter(origIter *msgpack.Iterator, e *Entry, br ringbuf.RingBufferReader, c Codec, hasOptions bool) (err error) {
 	origIter.SetManualFlush(false)
 
-	if m.gzip, err = m.t.gzipPool.Get(br); err != nil {
-		return
+	var r io.Reader
+
+	switch c {
+
+	case CodecGzip:
+		if m.gzip, err = m.t.gzipPool.Get(br); err != nil {
+			return
+		}
+
+		r = m.gzip
+
+	case CodecZstd:
+		if m.zstd, err = m.t.zstdPool.Get(br); err != nil {
+			return m.t.error("zstd", err)
+		}
+
+		r = m.zstd
+
+	default:
+		return m.t.error("compression", fmt.Errorf("%w: %s", ErrUnknownCompression, c))
+
+	}
+
+	m.t.chunk.Mode = ModeCompressedPackedForward
+	m.t.chunk.Compression = c
+
+	if max := m.t.limits.MaxDecompressedSize; max > 0 {
+		m.limiter = sizeLimiter{r: r, n: max}
+		r = &m.limiter
 	}
 
-	m.iter = m.t.iterPool.Get(m.gzip)
+	m.iter = m.t.iterPool.Get(r)
 	m.tag = strings.Clone(e.Tag)
 	m.hasOptions = hasOptions
 
@@ -76,6 +106,7 @@ func (m *CompressedPackedForwardMode) Next(origIter *msgpack.Iterator, e *Entry)
 	}
 
 	e.Record = m.iter
+	m.t.chunkEntry(e)
 
 	return
 }
@@ -83,13 +114,18 @@ func (m *CompressedPackedForwardMode) Next(origIter *msgpack.Iterator, e *Entry)
 // Leave implements Mode.
 func (m *CompressedPackedForwardMode) Leave(origIter *msgpack.Iterator) (err error) {
 	m.t.iterPool.Put(m.iter)
-	m.t.gzipPool.Put(m.gzip)
 	m.iter = nil
-	m.gzip = nil
+	m.limiter = sizeLimiter{}
 
-	if m.hasOptions {
-		err = m.t.handleOptions(origIter)
+	if m.gzip != nil {
+		m.t.gzipPool.Put(m.gzip)
+		m.gzip = nil
 	}
 
-	return
+	if m.zstd != nil {
+		m.t.zstdPool.Put(m.zstd)
+		m.zstd = nil
+	}
+
+	return m.t.endChunk(origIter, m.hasOptions)
 }
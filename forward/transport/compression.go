@@ -0,0 +1,81 @@
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/webmafia/fast/ringbuf"
+)
+
+var ErrUnknownCompression = errors.New("unknown compression")
+
+// Compression codec of the entries in (Compressed)PackedForward mode.
+type Codec uint8
+
+const (
+	CodecNone Codec = iota
+	CodecGzip
+	CodecZstd
+)
+
+var codecStrings = [...]string{
+	"text",
+	"gzip",
+	"zstd",
+}
+
+func (c Codec) String() string {
+	if int(c) >= len(codecStrings) {
+		return fmt.Sprintf("(invalid codec %d)", c)
+	}
+
+	return codecStrings[c]
+}
+
+// Parses the value of a "compressed" option.
+func parseCodec(s string) (c Codec, err error) {
+	for i := range codecStrings {
+		if codecStrings[i] == s {
+			return Codec(i), nil
+		}
+	}
+
+	return 0, fmt.Errorf("%w: %q", ErrUnknownCompression, s)
+}
+
+// Detects the codec of binary entries by their magic number. As the "compressed" option
+// comes after the entries, it's verified first once they have been read. Uncompressed
+// entries always start with an array of 2 items.
+func detectCodec(r *ringbuf.LimitedReader) (c Codec, err error) {
+	b, err := r.Peek(1)
+
+	if err != nil {
+
+		// No entries at all
+		if err == io.EOF {
+			err = nil
+		}
+
+		return
+	}
+
+	switch b[0] {
+
+	case 0x92:
+		return CodecNone, nil
+
+	case 0x1f:
+		if ok, err := isGzip(r); err == nil && ok {
+			return CodecGzip, nil
+		}
+
+	case 0x28:
+		if ok, err := isZstd(r); err == nil && ok {
+			return CodecZstd, nil
+		}
+
+	}
+
+	return 0, fmt.Errorf("%w (magic number %#x)", ErrUnknownCompression, b[0])
+}
@@ -1,6 +1,8 @@
 package transport
 
 import (
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/webmafia/fluentlog/pkg/msgpack"
@@ -10,4 +12,77 @@ type Entry struct {
 	Tag       string
 	Timestamp time.Time
 	Record    *msgpack.Iterator
+
+	Chunk *Chunk // The chunk that the entry belongs to
+	Index int    // Index of the entry within its chunk
+}
+
+// Returns a copy of the entry that owns its data, and thereby can be kept after the next entry
+// has been read. The record is consumed.
+func (e *Entry) Clone() (o *OwnedEntry, err error) {
+	o = new(OwnedEntry)
+
+	if err = e.AppendTo(o); err != nil {
+		return nil, err
+	}
+
+	return
+}
+
+// Copies the entry into dst, reusing its record buffer (e.g. from an OwnedEntryPool). The
+// record is consumed.
+func (e *Entry) AppendTo(dst *OwnedEntry) (err error) {
+	dst.Tag = strings.Clone(e.Tag)
+	dst.Timestamp = e.Timestamp
+	dst.Chunk = 0
+	dst.Index = e.Index
+
+	if e.Chunk != nil {
+		dst.Chunk = e.Chunk.Seq
+	}
+
+	dst.Record, err = e.AppendRecord(dst.Record[:0])
+	return
+}
+
+// Appends the raw bytes of the record to dst, without decoding it. The record is consumed.
+func (e *Entry) AppendRecord(dst []byte) ([]byte, error) {
+	return e.Record.AppendValue(dst)
+}
+
+// An entry that owns its data, as returned by Entry.Clone.
+type OwnedEntry struct {
+	Tag       string
+	Timestamp time.Time
+	Record    msgpack.Value // Raw MessagePack map
+	Chunk     uint64        // Sequence number of the chunk that the entry belongs to
+	Index     int           // Index of the entry within its chunk
+}
+
+// Returns an iterator over the record, positioned at its map just like Entry.Record. Values
+// can also be read directly from Record, e.g. with Record.GetStr("message").
+func (o *OwnedEntry) Iter() (iter msgpack.Iterator) {
+	iter = msgpack.NewIterator(nil)
+	iter.ResetBytes(o.Record)
+	iter.Next()
+	return
+}
+
+// A pool of owned entries, whose record buffers are reused.
+type OwnedEntryPool struct {
+	pool sync.Pool
+}
+
+func (p *OwnedEntryPool) Get() *OwnedEntry {
+	if o, ok := p.pool.Get().(*OwnedEntry); ok {
+		return o
+	}
+
+	return new(OwnedEntry)
+}
+
+func (p *OwnedEntryPool) Put(o *OwnedEntry) {
+	o.Tag = ""
+	o.Record = o.Record[:0]
+	p.pool.Put(o)
 }
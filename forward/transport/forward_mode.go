@@ -32,6 +32,7 @@ func (m *ForwardMode) Enter(iter *msgpack.Iterator, e *Entry, items int, hasOpti
 	m.tag = append(m.tag[:0], e.Tag...)
 	m.items = items
 	m.hasOptions = hasOptions
+	m.t.chunk.Mode = ModeForward
 	return m.t.changeMode(m, iter, e)
 }
 
@@ -66,6 +67,7 @@ func (m *ForwardMode) Next(iter *msgpack.Iterator, e *Entry) (err error) {
 	}
 
 	e.Record = iter
+	m.t.chunkEntry(e)
 
 	m.items--
 	return
@@ -73,9 +75,5 @@ func (m *ForwardMode) Next(iter *msgpack.Iterator, e *Entry) (err error) {
 
 // Leave implements Mode.
 func (m *ForwardMode) Leave(iter *msgpack.Iterator) (err error) {
-	if m.hasOptions {
-		err = m.t.handleOptions(iter)
-	}
-
-	return
+	return m.t.endChunk(iter, m.hasOptions)
 }
@@ -0,0 +1,39 @@
+package transport
+
+import (
+	"errors"
+	"io"
+)
+
+var (
+	ErrMessageTooLarge      = errors.New("message too large")
+	ErrDecompressedTooLarge = errors.New("decompressed chunk too large")
+)
+
+type Limits struct {
+	MaxMessageSize      int // Max size in bytes of a binary chunk in (Compressed)PackedForward mode. Zero means unlimited.
+	MaxDecompressedSize int // Max decompressed size in bytes of a chunk in CompressedPackedForward mode. Zero means unlimited.
+}
+
+// Limits the total number of bytes read, and fails (instead of returning io.EOF) when
+// there is more to read.
+type sizeLimiter struct {
+	r io.Reader
+	n int
+}
+
+func (l *sizeLimiter) Read(p []byte) (n int, err error) {
+
+	// Read one byte past the limit, to tell whether there is more
+	if len(p) > l.n+1 {
+		p = p[:l.n+1]
+	}
+
+	n, err = l.r.Read(p)
+
+	if l.n -= n; l.n < 0 {
+		return 0, ErrDecompressedTooLarge
+	}
+
+	return
+}
@@ -2,6 +2,7 @@ package transport
 
 import (
 	"fmt"
+	"io"
 
 	"github.com/webmafia/fluentlog/pkg/msgpack"
 	"github.com/webmafia/fluentlog/pkg/msgpack/types"
@@ -15,7 +16,9 @@ const (
 var _ Mode = (*MessageMode)(nil)
 
 type MessageMode struct {
-	t *TransportPhase
+	t          *TransportPhase
+	pending    bool // Whether the chunk of the previous entry has yet to be ended
+	hasOptions bool
 }
 
 // Rewind implements Mode.
@@ -35,12 +38,34 @@ func (m *MessageMode) Enter(iter *msgpack.Iterator, e *Entry) error {
 
 // Next implements Mode.
 func (m *MessageMode) Next(iter *msgpack.Iterator, e *Entry) (err error) {
+
+	// Options of previous message are read once its record has been consumed
+	if m.pending {
+		m.pending = false
+
+		if err = m.t.endChunk(iter, m.hasOptions); err != nil {
+			return
+		}
+	}
+
+	// Between chunks - stop here if requested, or tell that we're idle while waiting. The
+	// order matters, as Stop is called from another goroutine before checking Idle.
+	m.t.idle.Store(true)
+
+	if m.t.stopped.Load() {
+		m.t.idle.Store(false)
+		return io.EOF
+	}
+
 	iter.Flush()
 
+	// On failure we're still idle, as nothing of the next chunk has been read
 	if err = iter.NextExpectedType(types.Array); err != nil {
 		return m.t.error("array_head", err)
 	}
 
+	m.t.idle.Store(false)
+
 	evLen := iter.Items()
 
 	// Abort early if invalid data
@@ -48,6 +73,8 @@ func (m *MessageMode) Next(iter *msgpack.Iterator, e *Entry) (err error) {
 		return m.t.error("array_head", fmt.Errorf("unexpected array length: %d", evLen))
 	}
 
+	m.t.beginChunk()
+
 	// 1) Tag
 	if err = iter.NextExpectedType(types.Str); err != nil {
 		return m.t.errorNoEof("tag", err)
@@ -76,17 +103,21 @@ func (m *MessageMode) Next(iter *msgpack.Iterator, e *Entry) (err error) {
 		return m.t.forwardMode.Enter(iter, e, iter.Items(), evLen == 3)
 
 	case types.Bin:
+		if max := m.t.limits.MaxMessageSize; max > 0 && iter.Len() > max {
+			return m.t.error("entries", fmt.Errorf("%w: %d bytes, max %d", ErrMessageTooLarge, iter.Len(), max))
+		}
+
 		limitR := iter.Reader()
-		isGzip, err := isGzip(limitR)
+		codec, err := detectCodec(limitR)
 
 		if err != nil {
-			return m.t.error("is_gzip", err)
+			return m.t.error("compression", err)
 		}
 
 		// iter.SetManualFlush(false)
 
-		if isGzip {
-			return m.t.compMode.Enter(iter, e, limitR, evLen == 3)
+		if codec != CodecNone {
+			return m.t.compMode.Enter(iter, e, limitR, codec, evLen == 3)
 		}
 
 		return m.t.packedMode.Enter(iter, e, limitR, evLen == 3)
@@ -104,11 +135,11 @@ func (m *MessageMode) Next(iter *msgpack.Iterator, e *Entry) (err error) {
 	}
 
 	e.Record = iter
+	m.t.chunkEntry(e)
 
-	// 4) Options
-	if evLen == 4 {
-		return m.t.handleOptions(iter)
-	}
+	// 4) Options (handled on next call)
+	m.pending = true
+	m.hasOptions = evLen == 4
 
 	return
 }
@@ -34,6 +34,7 @@ func (m *PackedForwardMode) Enter(origIter *msgpack.Iterator, e *Entry, r io.Rea
 	m.iter = m.t.iterPool.Get(r)
 	m.tag = append(m.tag[:0], e.Tag...)
 	m.hasOptions = hasOptions
+	m.t.chunk.Mode = ModePackedForward
 	return m.t.changeMode(m, origIter, e)
 }
 
@@ -68,6 +69,7 @@ func (m *PackedForwardMode) Next(origIter *msgpack.Iterator, e *Entry) (err erro
 	}
 
 	e.Record = m.iter
+	m.t.chunkEntry(e)
 
 	return
 }
@@ -77,9 +79,5 @@ func (m *PackedForwardMode) Leave(origIter *msgpack.Iterator) (err error) {
 	m.t.iterPool.Put(m.iter)
 	m.iter = nil
 
-	if m.hasOptions {
-		err = m.t.handleOptions(origIter)
-	}
-
-	return
+	return m.t.endChunk(origIter, m.hasOptions)
 }
@@ -3,9 +3,11 @@ package transport
 import (
 	"fmt"
 	"io"
+	"sync/atomic"
 
 	"github.com/webmafia/fast"
 	"github.com/webmafia/fluentlog/internal/gzip"
+	"github.com/webmafia/fluentlog/internal/zstd"
 	"github.com/webmafia/fluentlog/pkg/msgpack"
 	"github.com/webmafia/fluentlog/pkg/msgpack/types"
 )
@@ -13,18 +15,27 @@ import (
 type TransportPhase struct {
 	iterPool    *msgpack.IterPool
 	gzipPool    *gzip.Pool
-	ack         func(chunk string) error
+	zstdPool    *zstd.Pool
+	done        func(c *Chunk) error
 	mode        Mode
 	messageMode MessageMode
 	forwardMode ForwardMode
 	packedMode  PackedForwardMode
 	compMode    CompressedPackedForwardMode
+	limits      Limits
+	stopped     atomic.Bool
+	idle        atomic.Bool   // Waiting for the next entry between chunks
+	seq         atomic.Uint64 // Sequence number of the current chunk
+	chunk       Chunk
 }
 
-func (t *TransportPhase) Init(iterPool *msgpack.IterPool, gzipPool *gzip.Pool, ack func(chunk string) error) {
+// Initializes the phase. The done callback is called once each chunk has been read, including
+// its options, and can e.g. acknowledge it.
+func (t *TransportPhase) Init(iterPool *msgpack.IterPool, gzipPool *gzip.Pool, zstdPool *zstd.Pool, done func(c *Chunk) error) {
 	t.iterPool = iterPool
 	t.gzipPool = gzipPool
-	t.ack = ack
+	t.zstdPool = zstdPool
+	t.done = done
 	t.messageMode.t = t
 	t.forwardMode.t = t
 	t.packedMode.t = t
@@ -36,6 +47,32 @@ func (t *TransportPhase) Next(iter *msgpack.Iterator, e *Entry) error {
 	return t.mode.Next(iter, fast.Noescape(e))
 }
 
+func (t *TransportPhase) SetLimits(limits Limits) {
+	t.limits = limits
+}
+
+// Stops the phase at the next chunk boundary, after which Next returns io.EOF. Safe to call
+// from another goroutine.
+func (t *TransportPhase) Stop() {
+	t.stopped.Store(true)
+}
+
+func (t *TransportPhase) Stopped() bool {
+	return t.stopped.Load()
+}
+
+// Returns the sequence number of the latest chunk, or zero if none. Safe to call from
+// another goroutine.
+func (t *TransportPhase) Chunk() uint64 {
+	return t.seq.Load()
+}
+
+// Whether the phase is waiting for the next entry between chunks, i.e. whether a blocking
+// read can be interrupted without losing anything. Safe to call from another goroutine.
+func (t *TransportPhase) Idle() bool {
+	return t.idle.Load()
+}
+
 func (t *TransportPhase) changeMode(mode Mode, iter *msgpack.Iterator, e *Entry) (err error) {
 	if mode == t.mode {
 		return fmt.Errorf("already in %s", mode)
@@ -50,6 +87,7 @@ func (t *TransportPhase) changeMode(mode Mode, iter *msgpack.Iterator, e *Entry)
 	return t.Next(iter, e)
 }
 
+// Reads the options of the current chunk.
 func (t *TransportPhase) handleOptions(iter *msgpack.Iterator) (err error) {
 	if err = iter.NextExpectedType(types.Map); err != nil {
 		return t.errorNoEof("ack", err)
@@ -69,13 +107,32 @@ func (t *TransportPhase) handleOptions(iter *msgpack.Iterator) (err error) {
 			return t.errorNoEof("ack", io.ErrUnexpectedEOF)
 		}
 
-		if key != "chunk" {
+		switch key {
+
+		case "chunk":
+			t.chunk.ID = iter.Str()
+
+		case "size":
+			if typ := iter.Type(); typ != types.Int && typ != types.Uint {
+				return t.error("size", fmt.Errorf("expected integer, got %s", typ))
+			}
+
+			t.chunk.Size = int(iter.Int())
+
+		case "compressed":
+			declared, err := parseCodec(iter.Str())
+
+			if err != nil {
+				return t.error("compressed", err)
+			}
+
+			if c := t.chunk.Compression; declared != c {
+				return t.error("compressed", fmt.Errorf("declared as %s, but was %s", declared, c))
+			}
+
+		default:
 			iter.Skip()
-			continue
-		}
 
-		if err = t.ack(iter.Str()); err != nil {
-			return t.error("ack", err)
 		}
 	}
 
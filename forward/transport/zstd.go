@@ -0,0 +1,30 @@
+package transport
+
+import (
+	"io"
+
+	"github.com/webmafia/fast/ringbuf"
+)
+
+func isZstd(r *ringbuf.LimitedReader) (ok bool, err error) {
+	magicNumbers, err := r.Peek(4)
+
+	// Too short to be a zstd frame
+	if err == io.EOF {
+		return false, nil
+	}
+
+	if err != nil {
+		return
+	}
+
+	// Bounds check hint to compiler; see golang.org/issue/14808
+	_ = magicNumbers[3]
+
+	ok = (magicNumbers[0] == 0x28 &&
+		magicNumbers[1] == 0xb5 &&
+		magicNumbers[2] == 0x2f &&
+		magicNumbers[3] == 0xfd)
+
+	return
+}
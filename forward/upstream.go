@@ -0,0 +1,290 @@
+package forward
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var _ io.WriteCloser = (*UpstreamClient)(nil)
+
+type Balance uint8
+
+const (
+	// Always use the first available upstream, in the order they were provided.
+	Failover Balance = iota
+
+	// Spread writes over all available upstreams, proportionally to their weight.
+	RoundRobin
+)
+
+type Upstream struct {
+	Addr    string
+	Weight  int // Relative weight when using RoundRobin. Defaults to 1.
+	Options ClientOptions
+}
+
+type UpstreamOptions struct {
+	Balance Balance
+
+	// Interval of UDP heartbeats to each upstream. Zero disables heartbeats, in which
+	// case the health of an upstream is solely based on its connection state. Upstreams
+	// on Unix sockets never get any heartbeats.
+	HeartbeatInterval time.Duration
+
+	// Timeout of each heartbeat. Defaults to 1 second.
+	HeartbeatTimeout time.Duration
+
+	// Called whenever an upstream changes health. Must not block.
+	OnHealthChange func(addr string, healthy bool)
+}
+
+// A client that manages a group of upstream servers, with health tracking, failover
+// and load balancing. Implements io.Writer, BatchWriter and Reconnector, so that it
+// can be used as a drop-in replacement of Client.
+type UpstreamClient struct {
+	ups   []*upstream
+	opt   UpstreamOptions
+	mu    sync.Mutex // Protects the weights of the round-robin
+	close chan struct{}
+	wg    sync.WaitGroup
+}
+
+type upstream struct {
+	cli     *Client
+	weight  int
+	current int // Current weight in the smooth weighted round-robin
+	healthy atomic.Bool
+}
+
+// Whether the upstream should be tried. An upstream that is down becomes available
+// again once its backoff interval has passed.
+func (u *upstream) available() bool {
+	if !u.healthy.Load() {
+		return false
+	}
+
+	wait, _ := u.cli.retryIn()
+	return wait <= 0
+}
+
+func NewUpstreamClient(upstreams []Upstream, opt UpstreamOptions) *UpstreamClient {
+	if opt.HeartbeatTimeout <= 0 {
+		opt.HeartbeatTimeout = time.Second
+	}
+
+	c := &UpstreamClient{
+		ups:   make([]*upstream, len(upstreams)),
+		opt:   opt,
+		close: make(chan struct{}),
+	}
+
+	for i, up := range upstreams {
+		u := &upstream{
+			cli:    NewClient(up.Addr, up.Options),
+			weight: max(up.Weight, 1),
+		}
+
+		u.healthy.Store(true)
+		c.ups[i] = u
+
+		// Unix sockets have no UDP counterpart to send heartbeats to.
+		if opt.HeartbeatInterval > 0 && !isUnix(up.Addr) {
+			c.wg.Add(1)
+			go c.heartbeat(u)
+		}
+	}
+
+	return c
+}
+
+// Returns the upstreams to try, in order of preference. Only the choice is made under the
+// lock, while the writes are not, so that a slow upstream never holds up the others. Each
+// client keeps track of its own failures.
+func (c *UpstreamClient) candidates(dst []*upstream) []*upstream {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.opt.Balance == RoundRobin {
+		var (
+			best  *upstream
+			total int
+		)
+
+		for _, u := range c.ups {
+			if !u.available() {
+				continue
+			}
+
+			u.current += u.weight
+			total += u.weight
+
+			if best == nil || u.current > best.current {
+				best = u
+			}
+		}
+
+		if best != nil {
+			best.current -= total
+			dst = append(dst, best)
+		}
+	}
+
+	for _, u := range c.ups {
+		if len(dst) > 0 && dst[0] == u {
+			continue
+		}
+
+		if u.available() {
+			dst = append(dst, u)
+		}
+	}
+
+	return dst
+}
+
+// Write implements io.Writer. On failure, the next available upstream is tried.
+func (c *UpstreamClient) Write(b []byte) (n int, err error) {
+	var buf [8]*upstream
+	ups := c.candidates(buf[:0])
+
+	if len(ups) == 0 {
+		return 0, ErrNoUpstream
+	}
+
+	for _, u := range ups {
+		if n, err = u.cli.Write(b); err == nil {
+			return
+		}
+	}
+
+	return
+}
+
+// WriteBatch implements BatchWriter. The next available upstream is only tried on
+// failure if the reader is an io.Seeker (e.g. a file), as the batch must be re-read.
+func (c *UpstreamClient) WriteBatch(tag string, size int, r io.Reader) (err error) {
+	var buf [8]*upstream
+	ups := c.candidates(buf[:0])
+
+	if len(ups) == 0 {
+		return ErrNoUpstream
+	}
+
+	seeker, canSeek := r.(io.Seeker)
+
+	for i, u := range ups {
+		if i > 0 {
+			if !canSeek {
+				break
+			}
+
+			if _, err = seeker.Seek(0, io.SeekStart); err != nil {
+				return
+			}
+		}
+
+		if err = u.cli.WriteBatch(tag, size, r); err == nil {
+			return
+		}
+	}
+
+	return
+}
+
+// Reconnect implements Reconnector. Any upstream without a connection is reconnected,
+// and an error is only returned if no upstream could be connected.
+func (c *UpstreamClient) Reconnect() (err error) {
+	var (
+		errs      []error
+		connected bool
+	)
+
+	for _, u := range c.ups {
+		if err := u.cli.connectIfNeeded(); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		connected = true
+	}
+
+	if connected {
+		return nil
+	}
+
+	return errors.Join(append(errs, ErrNoUpstream)...)
+}
+
+// DrainUnacked implements fluentlog.AckWriter.
+func (c *UpstreamClient) DrainUnacked(fn func(b []byte)) {
+	for _, u := range c.ups {
+		u.cli.DrainUnacked(fn)
+	}
+}
+
+// Close implements io.WriteCloser.
+func (c *UpstreamClient) Close() (err error) {
+	select {
+	case <-c.close:
+		return
+	default:
+		close(c.close)
+	}
+
+	c.wg.Wait()
+
+	var errs []error
+
+	for _, u := range c.ups {
+		if err := u.cli.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Sends UDP heartbeats to the upstream, that are echoed back by the server.
+func (c *UpstreamClient) heartbeat(u *upstream) {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(c.opt.HeartbeatInterval)
+	defer ticker.Stop()
+
+	var buf [1]byte
+
+	for {
+		select {
+		case <-c.close:
+			return
+		case <-ticker.C:
+		}
+
+		healthy := func() bool {
+			conn, err := net.DialTimeout("udp", u.cli.addr, c.opt.HeartbeatTimeout)
+
+			if err != nil {
+				return false
+			}
+
+			defer conn.Close()
+
+			conn.SetDeadline(time.Now().Add(c.opt.HeartbeatTimeout))
+
+			if _, err = conn.Write(buf[:]); err != nil {
+				return false
+			}
+
+			_, err = conn.Read(buf[:])
+			return err == nil
+		}()
+
+		if u.healthy.Swap(healthy) != healthy && c.opt.OnHealthChange != nil {
+			c.opt.OnHealthChange(u.cli.addr, healthy)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package forward
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/webmafia/fluentlog/pkg/msgpack"
+)
+
+// Starts a server whose received messages are sent to the returned channel.
+func testUpstream(t *testing.T) (addr string, msgs chan string) {
+	msgs = make(chan string, 10)
+
+	_, addr = testServer(t, ServerOptions{Unauthenticated: true}, func(ctx context.Context, ss *ServerSession) error {
+		return readMessages(ss, msgs)
+	})
+
+	return
+}
+
+// Returns a gzip-compressed batch of a single entry, as written by WriteBatch.
+func testBatch(t *testing.T, message string) []byte {
+	t.Helper()
+
+	b := msgpack.AppendArrayHeader(nil, 2)
+	b = msgpack.AppendTimestamp(b, time.Unix(1700000000, 0), msgpack.TsFluentd)
+	b = msgpack.AppendMapHeader(b, 1)
+	b = msgpack.AppendString(b, "message")
+	b = msgpack.AppendString(b, message)
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+
+	if _, err := gz.Write(b); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func testUpstreamOptions() ClientOptions {
+	return ClientOptions{
+		Unauthenticated: true,
+		Backoff: Backoff{
+			InitialInterval: 50 * time.Millisecond,
+			NoJitter:        true,
+		},
+	}
+}
+
+func TestUpstreamClient_Failover(t *testing.T) {
+	addrA, msgsA := testUpstream(t)
+	addrB, msgsB := testUpstream(t)
+
+	c := NewUpstreamClient([]Upstream{
+		{Addr: addrA, Options: testUpstreamOptions()},
+		{Addr: addrB, Options: testUpstreamOptions()},
+	}, UpstreamOptions{})
+
+	defer c.Close()
+
+	// The first upstream is always preferred
+	for _, msg := range []string{"a", "b", "c"} {
+		if _, err := c.Write(testEntry("test", msg)); err != nil {
+			t.Fatal(err)
+		}
+
+		if got := receive(t, msgsA); got != msg {
+			t.Errorf("expected %q on the first upstream, got %q", msg, got)
+		}
+	}
+
+	select {
+	case msg := <-msgsB:
+		t.Errorf("unexpected %q on the second upstream", msg)
+	default:
+	}
+}
+
+func TestUpstreamClient_FailoverDown(t *testing.T) {
+	addrB, msgsB := testUpstream(t)
+
+	c := NewUpstreamClient([]Upstream{
+		{Addr: testAddr(t), Options: testUpstreamOptions()},
+		{Addr: addrB, Options: testUpstreamOptions()},
+	}, UpstreamOptions{})
+
+	defer c.Close()
+
+	if _, err := c.Write(testEntry("test", "a")); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := receive(t, msgsB); got != "a" {
+		t.Errorf("expected \"a\" on the second upstream, got %q", got)
+	}
+
+	// The upstream that is down is skipped until its backoff has passed
+	if ups := c.candidates(nil); len(ups) != 1 || ups[0] != c.ups[1] {
+		t.Errorf("expected only the second upstream as candidate, got %d", len(ups))
+	}
+
+	waitFor(t, "backoff to pass", func() bool {
+		ups := c.candidates(nil)
+		return len(ups) == 2 && ups[0] == c.ups[0]
+	})
+}
+
+func TestUpstreamClient_RoundRobin(t *testing.T) {
+	c := NewUpstreamClient([]Upstream{
+		{Addr: testAddr(t), Weight: 3},
+		{Addr: testAddr(t)},
+	}, UpstreamOptions{Balance: RoundRobin})
+
+	defer c.Close()
+
+	var counts [2]int
+
+	for range 8 {
+		ups := c.candidates(nil)
+
+		if len(ups) != 2 || ups[0] == ups[1] {
+			t.Fatalf("expected both upstreams as candidates, got %d", len(ups))
+		}
+
+		if ups[0] == c.ups[0] {
+			counts[0]++
+		} else {
+			counts[1]++
+		}
+	}
+
+	if counts != [2]int{6, 2} {
+		t.Errorf("expected a 3:1 split, got %v", counts)
+	}
+}
+
+func TestUpstreamClient_WriteBatchRetry(t *testing.T) {
+	tests := []struct {
+		name   string
+		reader func(b []byte) io.Reader
+		retry  bool
+	}{
+		{"Seeker", func(b []byte) io.Reader { return bytes.NewReader(b) }, true},
+		{"NonSeeker", func(b []byte) io.Reader { return io.MultiReader(bytes.NewReader(b)) }, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addrB, msgsB := testUpstream(t)
+
+			// The first upstream is down, which is only noticed on the first write
+			c := NewUpstreamClient([]Upstream{
+				{Addr: testAddr(t), Options: testUpstreamOptions()},
+				{Addr: addrB, Options: testUpstreamOptions()},
+			}, UpstreamOptions{})
+
+			defer c.Close()
+
+			batch := testBatch(t, tt.name)
+			err := c.WriteBatch("test", len(batch), tt.reader(batch))
+
+			if !tt.retry {
+				if err == nil {
+					t.Fatal("expected the batch to fail without retry")
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := receive(t, msgsB); got != tt.name {
+				t.Errorf("expected %q on the second upstream, got %q", tt.name, got)
+			}
+		})
+	}
+}
+
+func TestUpstreamClient_Heartbeat(t *testing.T) {
+	udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer udp.Close()
+
+	var respond atomic.Bool
+	respond.Store(true)
+
+	// Echoes heartbeats while responding
+	go func() {
+		var buf [16]byte
+
+		for {
+			n, addr, err := udp.ReadFrom(buf[:])
+
+			if err != nil {
+				return
+			}
+
+			if respond.Load() {
+				udp.WriteTo(buf[:n], addr)
+			}
+		}
+	}()
+
+	changes := make(chan bool, 10)
+
+	c := NewUpstreamClient([]Upstream{
+		{Addr: udp.LocalAddr().String()},
+	}, UpstreamOptions{
+		HeartbeatInterval: 10 * time.Millisecond,
+		HeartbeatTimeout:  50 * time.Millisecond,
+		OnHealthChange: func(addr string, healthy bool) {
+			changes <- healthy
+		},
+	})
+
+	defer c.Close()
+
+	respond.Store(false)
+
+	for _, expected := range []bool{false, true} {
+		select {
+		case healthy := <-changes:
+			if healthy != expected {
+				t.Fatalf("expected healthy=%v, got %v", expected, healthy)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for a health change")
+		}
+
+		if c.ups[0].healthy.Load() != expected {
+			t.Errorf("expected the upstream's health to be %v", expected)
+		}
+
+		respond.Store(true)
+	}
+}
@@ -0,0 +1,228 @@
+package forward
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"github.com/webmafia/fluentlog/forward/transport"
+)
+
+// Handles an owned entry of a session, in a worker goroutine. The session must not be read from,
+// and the entry is reused once the handler has returned.
+type OwnedEntryHandler func(ctx context.Context, ss *ServerSession, e *transport.OwnedEntry) error
+
+type WorkerPoolOptions struct {
+	Workers   int // Number of worker goroutines. Defaults to the number of CPUs.
+	QueueSize int // Number of entries queued per session. Defaults to 64.
+}
+
+func (opt *WorkerPoolOptions) setDefaults() {
+	if opt.Workers <= 0 {
+		opt.Workers = runtime.NumCPU()
+	}
+
+	if opt.QueueSize <= 0 {
+		opt.QueueSize = 64
+	}
+}
+
+// A worker pool handles the entries of sessions in parallel. Sessions with queued entries
+// share the workers, but are only handled by one worker at a time, so that their entries are
+// handled in order without a slow session holding up any other. With ManualAck, chunks are
+// acknowledged once all their entries have been handled. If the handler fails, the session
+// is closed without acknowledging the chunk, and the handler's error is returned by Serve.
+type WorkerPool struct {
+	handler OwnedEntryHandler
+	opt     WorkerPoolOptions
+	ready   chan *poolSession // Sessions with queued items, that aren't handled by any worker
+	entries transport.OwnedEntryPool
+	wg      sync.WaitGroup
+}
+
+// An entry to handle, a chunk to acknowledge, or the end of a session.
+type workItem struct {
+	s     *poolSession
+	entry *transport.OwnedEntry
+	ack   uint64
+	done  chan struct{}
+}
+
+type poolSession struct {
+	p       *WorkerPool
+	ctx     context.Context
+	ss      *ServerSession
+	queue   chan workItem
+	pending atomic.Int64 // Number of queued items not yet handled
+	mu      sync.Mutex
+	err     error
+}
+
+func NewWorkerPool(handler OwnedEntryHandler, options ...WorkerPoolOptions) *WorkerPool {
+	var opt WorkerPoolOptions
+
+	if len(options) > 0 {
+		opt = options[0]
+	}
+
+	opt.setDefaults()
+
+	p := &WorkerPool{
+		handler: handler,
+		opt:     opt,
+		ready:   make(chan *poolSession, opt.Workers),
+	}
+
+	p.wg.Add(opt.Workers)
+
+	for range opt.Workers {
+		go p.work()
+	}
+
+	return p
+}
+
+// Serve reads the entries of a session and queues them, until an error occurs. It has the
+// signature of a Server.Listen handler, and returns once all queued entries of the session
+// have been handled. The session's OnChunk is used by the pool.
+func (p *WorkerPool) Serve(ctx context.Context, ss *ServerSession) (err error) {
+	s := &poolSession{
+		p:     p,
+		ctx:   ctx,
+		ss:    ss,
+		queue: make(chan workItem, p.opt.QueueSize),
+	}
+
+	defer s.wait()
+
+	ss.OnChunk(func(c *transport.Chunk) error {
+		return s.send(workItem{ack: c.Seq})
+	})
+
+	for e, err := range ss.Entries() {
+		if err != nil {
+			if errors.Is(err, ErrReadTimeout) {
+				continue
+			}
+
+			return s.cause(err)
+		}
+
+		o := p.entries.Get()
+
+		if err = e.AppendTo(o); err != nil {
+			p.entries.Put(o)
+			return err
+		}
+
+		if err = s.send(workItem{entry: o}); err != nil {
+			p.entries.Put(o)
+			return s.cause(err)
+		}
+	}
+
+	return s.cause(nil)
+}
+
+// Close stops all workers, once they have handled their queued entries. It must not be called
+// until all sessions have ended.
+func (p *WorkerPool) Close() {
+	close(p.ready)
+	p.wg.Wait()
+}
+
+// Handles the queued items of one ready session at a time, until it has none left.
+func (p *WorkerPool) work() {
+	defer p.wg.Done()
+
+	for s := range p.ready {
+		for {
+			s.handle(<-s.queue)
+
+			if s.pending.Add(-1) == 0 {
+				break
+			}
+		}
+	}
+}
+
+func (s *poolSession) send(item workItem) error {
+	item.s = s
+
+	select {
+
+	case s.queue <- item:
+		s.schedule()
+		return nil
+
+	case <-s.ctx.Done():
+		return s.ctx.Err()
+
+	}
+}
+
+// Hands the session to a worker, unless one is already handling it. Once an item is queued,
+// it must be scheduled, regardless of the context.
+func (s *poolSession) schedule() {
+	if s.pending.Add(1) == 1 {
+		s.p.ready <- s
+	}
+}
+
+// Waits until all queued items of the session have been handled.
+func (s *poolSession) wait() {
+	done := make(chan struct{})
+	s.queue <- workItem{s: s, done: done}
+	s.schedule()
+	<-done
+}
+
+func (s *poolSession) handle(item workItem) {
+	if item.done != nil {
+		close(item.done)
+		return
+	}
+
+	if item.entry != nil {
+		defer s.p.entries.Put(item.entry)
+	}
+
+	if s.cause(nil) != nil {
+		return
+	}
+
+	var (
+		err error
+		seq = item.ack
+	)
+
+	if item.entry != nil {
+		seq = item.entry.Chunk
+		err = s.p.handler(s.ctx, s.ss, item.entry)
+	} else {
+		err = s.ss.Ack(item.ack)
+	}
+
+	if err != nil {
+		s.mu.Lock()
+		s.err = err
+		s.mu.Unlock()
+
+		// Interrupts the session without acknowledging the chunk
+		s.ss.Nack(seq)
+	}
+}
+
+// Returns the handler's error if it has failed, or otherwise err.
+func (s *poolSession) cause(err error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err != nil {
+		return s.err
+	}
+
+	return err
+}
@@ -0,0 +1,78 @@
+package forward
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/webmafia/fluentlog/forward/transport"
+)
+
+func TestWorkerPool_SlowSession(t *testing.T) {
+	const (
+		clients = 4
+		writes  = 10
+	)
+
+	var (
+		release = make(chan struct{})
+		slow    = make(chan struct{}, 1)
+		msgs    = make(chan string, clients*writes)
+	)
+
+	pool := NewWorkerPool(func(ctx context.Context, ss *ServerSession, e *transport.OwnedEntry) error {
+		if e.Tag == "slow" {
+			slow <- struct{}{}
+			<-release
+			return nil
+		}
+
+		// The entry is reused once the handler has returned
+		msgs <- strings.Clone(e.Record.Get("message").Str())
+		return nil
+	}, WorkerPoolOptions{Workers: 2})
+
+	t.Cleanup(pool.Close)
+	defer close(release)
+
+	_, addr := testServer(t, ServerOptions{Unauthenticated: true}, pool.Serve)
+
+	slowCli := NewClient(addr, ClientOptions{Unauthenticated: true})
+	defer slowCli.Close()
+
+	if _, err := slowCli.Write(testEntry("slow", "")); err != nil {
+		t.Fatal(err)
+	}
+
+	<-slow
+
+	// The other sessions must be handled by the remaining worker meanwhile
+	for c := range clients {
+		cli := NewClient(addr, ClientOptions{Unauthenticated: true})
+		defer cli.Close()
+
+		for i := range writes {
+			if _, err := cli.Write(testEntry("test", fmt.Sprintf("%d-%d", c, i))); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// Entries must still be handled in order within each session
+	var next [clients]int
+
+	for range clients * writes {
+		var c, i int
+
+		if _, err := fmt.Sscanf(receive(t, msgs), "%d-%d", &c, &i); err != nil {
+			t.Fatal(err)
+		}
+
+		if i != next[c] {
+			t.Fatalf("session %d: expected entry %d, got %d", c, next[c], i)
+		}
+
+		next[c]++
+	}
+}
@@ -9,9 +9,7 @@ require (
 	github.com/segmentio/encoding v0.5.3
 	github.com/webmafia/fast v0.17.0
 	github.com/webmafia/hexid v1.0.0
+	golang.org/x/sys v0.0.0-20211110154304-99a53858aa08
 )
 
-require (
-	github.com/segmentio/asm v1.1.3 // indirect
-	golang.org/x/sys v0.0.0-20211110154304-99a53858aa08 // indirect
-)
+require github.com/segmentio/asm v1.1.3 // indirect
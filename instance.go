@@ -76,6 +76,10 @@ func NewInstance(cli io.Writer, options ...Options) (*Instance, error) {
 			return nil, errors.New("WriteBehavior set to 'Fallback', but client doesn't implement BatchWriter")
 		}
 
+		if err := inst.opt.Fallback.Lock(); err != nil {
+			return nil, err
+		}
+
 		// inst.wg.Add(1)
 		// go inst.fallbackWorker()
 	}
@@ -113,6 +117,10 @@ func (inst *Instance) Close() (err error) {
 	close(inst.close)
 	inst.wg.Wait()
 
+	if inst.opt.WriteBehavior == Fallback {
+		inst.drainUnacked()
+	}
+
 	if inst.opt.Fallback != nil {
 		if err = inst.opt.Fallback.Close(); err != nil {
 			return
@@ -292,6 +300,7 @@ func (inst *Instance) sendToCli(b *buffer.Buffer) {
 
 		if inst.opt.WriteBehavior == Fallback {
 			inst.fb = true
+			inst.drainUnacked()
 			inst.sendToFallbackCli(b)
 			return
 		}
@@ -301,15 +310,18 @@ func (inst *Instance) sendToCli(b *buffer.Buffer) {
 }
 
 func (inst *Instance) sendToFallbackCli(b *buffer.Buffer) {
+	inst.writeToFallback(b.B)
+	inst.bufPool.Put(b)
+}
+
+func (inst *Instance) writeToFallback(b []byte) {
 
 	// When writing to fallback, each entry should only consist of an array of 2 items (timestamp + record).
 	// For this reason, we must strip away the original array header + the tag string, then write an array
 	// header of 2 items + the rest of the entry.
 	strip := 1 + strSize(len(inst.opt.Tag))
 	inst.opt.Fallback.Write([]byte{0x90 | 2})
-	_, err := inst.opt.Fallback.Write(fast.Noescape(b.B[strip:]))
-
-	inst.bufPool.Put(b)
+	_, err := inst.opt.Fallback.Write(fast.Noescape(b[strip:]))
 
 	// Tell that there are messages in the fallback buffer
 	if err != nil {
@@ -317,6 +329,13 @@ func (inst *Instance) sendToFallbackCli(b *buffer.Buffer) {
 	}
 }
 
+// Moves any unacknowledged entries from the client to the fallback buffer.
+func (inst *Instance) drainUnacked() {
+	if cli, ok := inst.cli.(AckWriter); ok {
+		cli.DrainUnacked(inst.writeToFallback)
+	}
+}
+
 func (inst *Instance) flushFallbackToCli() (err error) {
 	if !inst.fb {
 		return
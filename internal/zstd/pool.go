@@ -0,0 +1,31 @@
+// Package zstd pools zstd decoders, as they are expensive to create.
+package zstd
+
+import (
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+type Decoder = zstd.Decoder
+
+type Pool struct {
+	pool sync.Pool
+}
+
+func (pool *Pool) Get(r io.Reader) (d *Decoder, err error) {
+	var ok bool
+
+	if d, ok = pool.pool.Get().(*Decoder); ok {
+		return d, d.Reset(r)
+	}
+
+	// Decode synchronously, as there is one decoder per session
+	return zstd.NewReader(r, zstd.WithDecoderConcurrency(1), zstd.WithDecoderLowmem(true))
+}
+
+func (pool *Pool) Put(d *Decoder) {
+	d.Reset(nil)
+	pool.pool.Put(d)
+}
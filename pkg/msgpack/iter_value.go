@@ -0,0 +1,76 @@
+package msgpack
+
+import (
+	"io"
+
+	"github.com/webmafia/fluentlog/pkg/msgpack/types"
+)
+
+// Appends the raw bytes of current value (including any nested values) to dst,
+// without decoding it. The result is a valid Value that is owned by the caller.
+func (iter *Iterator) AppendValue(dst []byte) (_ []byte, err error) {
+	_, l, isValueLength := types.Get(iter.byt)
+	dst = append(dst, iter.byt)
+
+	switch iter.typ {
+
+	case types.Array, types.Map:
+		items := iter.items
+
+		if !isValueLength {
+			dst = appendLength(dst, items, l)
+		}
+
+		if iter.typ == types.Map {
+			items *= 2
+		}
+
+		for range items {
+			if !iter.Next() {
+				if err = iter.err; err == nil || err == io.EOF {
+					err = io.ErrUnexpectedEOF
+				}
+
+				return dst, err
+			}
+
+			if dst, err = iter.AppendValue(dst); err != nil {
+				return
+			}
+		}
+
+	default:
+		n := iter.length
+
+		if !isValueLength {
+			dst = appendLength(dst, n, l)
+
+			// Extensions have their type byte after the length
+			if iter.typ == types.Ext {
+				n++
+			}
+		}
+
+		var b []byte
+
+		if b, err = iter.r.ReadBytes(n); err != nil {
+			return dst, err
+		}
+
+		dst = append(dst, b...)
+	}
+
+	return dst, nil
+}
+
+// Appends a big-endian length of l bytes.
+func appendLength(dst []byte, n int, l int) []byte {
+	switch l {
+	case 1:
+		return append(dst, byte(n))
+	case 2:
+		return append(dst, byte(n>>8), byte(n))
+	default:
+		return append(dst, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+}
@@ -0,0 +1,41 @@
+package msgpack
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestIterator_AppendValue(t *testing.T) {
+	var src []byte
+	src = AppendMapHeader(src, 4)
+	src = AppendString(src, "foo")
+	src = AppendString(src, "bar")
+	src = AppendString(src, "time")
+	src = AppendTimestamp(src, time.Now(), Ts96)
+	src = AppendString(src, "list")
+	src = AppendArrayHeader(src, 2)
+	src = AppendInt(src, -1234)
+	src = AppendBinary(src, make([]byte, 300))
+	src = AppendString(src, "nested")
+	src = AppendMapHeader(src, 1)
+	src = AppendString(src, "baz")
+	src = AppendFloat(src, 1.5)
+
+	iter := NewIterator(nil)
+	iter.ResetBytes(src)
+
+	if !iter.Next() {
+		t.Fatal(iter.Error())
+	}
+
+	dst, err := iter.AppendValue(nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(src, dst) {
+		t.Fatalf("expected %v, got %v", src, dst)
+	}
+}
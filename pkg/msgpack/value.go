@@ -2,12 +2,15 @@ package msgpack
 
 import (
 	"encoding"
+	"encoding/base64"
 	"encoding/hex"
 	"fmt"
 	"iter"
+	"math"
 	"strconv"
 	"time"
 
+	"github.com/segmentio/encoding/json"
 	"github.com/webmafia/fast"
 	"github.com/webmafia/fluentlog/pkg/msgpack/types"
 )
@@ -58,7 +61,7 @@ func (v Value) Array() iter.Seq[Value] {
 		v = v[offset:]
 
 		for range length {
-			next := v.BytesLen()
+			next := v.size()
 
 			if !yield(v[:next]) {
 				return
@@ -93,11 +96,11 @@ func (v Value) Map() iter.Seq2[Value, Value] {
 		v = v[offset:]
 
 		for range length {
-			next := v.BytesLen()
+			next := v.size()
 			key := v[:next]
 			v = v[next:]
 
-			next = v.BytesLen()
+			next = v.size()
 			val := v[:next]
 			v = v[next:]
 
@@ -133,6 +136,53 @@ func (v Value) BytesLen() (l int) {
 	return offset
 }
 
+// Returns the total number of bytes for the value, including the bodies of any
+// nested values. Returns the available bytes if the value is incomplete.
+func (v Value) size() (l int) {
+	if len(v) == 0 {
+		return
+	}
+
+	typ, length, isValueLength := types.Get(v[0])
+	offset := 1
+
+	if !isValueLength {
+		if len(v) < 1+length {
+			return len(v)
+		}
+
+		l := length
+		length = int(uintFromBuf[uint32](v[offset : offset+l]))
+		offset += l
+
+		// Extensions have their type byte after the length
+		if typ == types.Ext {
+			offset++
+		}
+	}
+
+	switch typ {
+
+	case types.Array, types.Map:
+		if typ == types.Map {
+			length *= 2
+		}
+
+		for range length {
+			if offset >= len(v) {
+				return len(v)
+			}
+
+			offset += v[offset:].size()
+		}
+
+	default:
+		offset += length
+	}
+
+	return min(offset, len(v))
+}
+
 // Whether the value has its full bytes.
 // func (v Value) IsComplete() bool {
 // 	l := len(v)
@@ -273,7 +323,97 @@ func (v Value) AppendBinary(b []byte) ([]byte, error) {
 	return append(b, v...), nil
 }
 
-// AppendJson implements fast.JsonAppender.
+// AppendJson implements fast.JsonAppender. Binary data is encoded as base64 strings,
+// and timestamps as RFC 3339 strings.
 func (v Value) AppendJson(b []byte) ([]byte, error) {
-	panic("not implemented")
+	if len(v) == 0 {
+		return append(b, "null"...), nil
+	}
+
+	switch v.Type() {
+
+	case types.Nil:
+		return append(b, "null"...), nil
+
+	case types.Bool:
+		return strconv.AppendBool(b, v.Bool()), nil
+
+	case types.Int:
+		return strconv.AppendInt(b, v.Int(), 10), nil
+
+	case types.Uint:
+		return strconv.AppendUint(b, v.Uint(), 10), nil
+
+	case types.Float:
+		f := v.Float()
+
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return append(b, "null"...), nil
+		}
+
+		return strconv.AppendFloat(b, f, 'f', -1, 64), nil
+
+	case types.Str:
+		return json.AppendEscape(b, v.Str(), 0), nil
+
+	case types.Bin:
+		b = append(b, '"')
+		b = base64.StdEncoding.AppendEncode(b, v.Bin())
+		return append(b, '"'), nil
+
+	case types.Ext:
+		b = append(b, '"')
+		b = v.Timestamp().AppendFormat(b, time.RFC3339Nano)
+		return append(b, '"'), nil
+
+	case types.Array:
+		b = append(b, '[')
+		var i int
+
+		for item := range v.Array() {
+			if i > 0 {
+				b = append(b, ',')
+			}
+
+			var err error
+
+			if b, err = item.AppendJson(b); err != nil {
+				return b, err
+			}
+
+			i++
+		}
+
+		return append(b, ']'), nil
+
+	case types.Map:
+		b = append(b, '{')
+		var i int
+
+		for key, val := range v.Map() {
+			if i > 0 {
+				b = append(b, ',')
+			}
+
+			var err error
+
+			if key.Type() == types.Str {
+				b = json.AppendEscape(b, key.Str(), 0)
+			} else {
+				b = json.AppendEscape(b, key.String(), 0)
+			}
+
+			b = append(b, ':')
+
+			if b, err = val.AppendJson(b); err != nil {
+				return b, err
+			}
+
+			i++
+		}
+
+		return append(b, '}'), nil
+	}
+
+	return b, ErrInvalidFormat
 }
@@ -0,0 +1,88 @@
+package msgpack
+
+import (
+	"time"
+
+	"github.com/webmafia/fluentlog/pkg/msgpack/types"
+)
+
+// Returns the value of a string key in a map, or a zero Value if not found. Several keys
+// look up a nested value, e.g. Get("http", "status").
+func (v Value) Get(keys ...string) Value {
+	for _, key := range keys {
+		if v.Type() != types.Map {
+			return nil
+		}
+
+		var found Value
+
+		for k, val := range v.Map() {
+			if k.Type() == types.Str && k.Str() == key {
+				found = val
+				break
+			}
+		}
+
+		if found == nil {
+			return nil
+		}
+
+		v = found
+	}
+
+	return v
+}
+
+// Returns the string of a key (see Get), and whether it was found as a string. The string
+// refers to the bytes of the value.
+func (v Value) GetStr(keys ...string) (val string, ok bool) {
+	if v = v.Get(keys...); v.Type() != types.Str {
+		return
+	}
+
+	val, _, err := ReadString(v, 0)
+	return val, err == nil
+}
+
+// Returns the integer of a key (see Get), and whether it was found as an integer.
+func (v Value) GetInt(keys ...string) (val int64, ok bool) {
+	val, _, err := ReadInt(v.Get(keys...), 0)
+	return val, err == nil
+}
+
+// Returns the unsigned integer of a key (see Get), and whether it was found as an unsigned
+// integer.
+func (v Value) GetUint(keys ...string) (val uint64, ok bool) {
+	val, _, err := ReadUint(v.Get(keys...), 0)
+	return val, err == nil
+}
+
+// Returns the number of a key (see Get), and whether it was found as a number. Integers
+// are converted.
+func (v Value) GetFloat(keys ...string) (val float64, ok bool) {
+	switch v = v.Get(keys...); v.Type() {
+
+	case types.Float:
+		val, _, err := ReadFloat(v, 0)
+		return val, err == nil
+
+	case types.Int, types.Uint:
+		i, _, err := ReadInt(v, 0)
+		return float64(i), err == nil
+
+	}
+
+	return
+}
+
+// Returns the boolean of a key (see Get), and whether it was found as a boolean.
+func (v Value) GetBool(keys ...string) (val bool, ok bool) {
+	val, _, err := ReadBool(v.Get(keys...), 0)
+	return val, err == nil
+}
+
+// Returns the timestamp of a key (see Get), and whether it was found as a timestamp.
+func (v Value) GetTimestamp(keys ...string) (val time.Time, ok bool) {
+	val, _, err := ReadTimestamp(v.Get(keys...), 0)
+	return val, err == nil
+}
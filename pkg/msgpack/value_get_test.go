@@ -0,0 +1,100 @@
+package msgpack
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func ExampleValue_Get() {
+	var v Value
+
+	v = AppendMapHeader(v, 2)
+	v = AppendString(v, "message")
+	v = AppendString(v, "hello")
+	v = AppendString(v, "http")
+	v = AppendMapHeader(v, 1)
+	v = AppendString(v, "status")
+	v = AppendInt(v, 404)
+
+	fmt.Println(v.Get("message").String())
+	fmt.Println(v.GetInt("http", "status"))
+	fmt.Println(v.GetInt("http", "method"))
+
+	// Output:
+	//
+	// hello
+	// 404 true
+	// 0 false
+}
+
+func TestValueGetters(t *testing.T) {
+	ts := time.Unix(1700000000, 123).UTC()
+
+	var v Value
+	v = AppendMapHeader(v, 7)
+	v = AppendString(v, "str")
+	v = AppendString(v, "foo")
+	v = AppendString(v, "int")
+	v = AppendInt(v, -42)
+	v = AppendString(v, "uint")
+	v = AppendUint(v, 42)
+	v = AppendString(v, "float")
+	v = AppendFloat(v, 1.5)
+	v = AppendString(v, "bool")
+	v = AppendBool(v, true)
+	v = AppendString(v, "time")
+	v = AppendTimestamp(v, ts)
+	v = AppendString(v, "nested")
+	v = AppendMapHeader(v, 1)
+	v = AppendString(v, "str")
+	v = AppendString(v, "bar")
+
+	if s, ok := v.GetStr("str"); !ok || s != "foo" {
+		t.Errorf("GetStr: got %q, %v", s, ok)
+	}
+
+	if s, ok := v.GetStr("nested", "str"); !ok || s != "bar" {
+		t.Errorf("GetStr nested: got %q, %v", s, ok)
+	}
+
+	if _, ok := v.GetStr("int"); ok {
+		t.Error("GetStr: expected int not to be a string")
+	}
+
+	if i, ok := v.GetInt("int"); !ok || i != -42 {
+		t.Errorf("GetInt: got %d, %v", i, ok)
+	}
+
+	if i, ok := v.GetInt("uint"); !ok || i != 42 {
+		t.Errorf("GetInt of uint: got %d, %v", i, ok)
+	}
+
+	if u, ok := v.GetUint("uint"); !ok || u != 42 {
+		t.Errorf("GetUint: got %d, %v", u, ok)
+	}
+
+	if f, ok := v.GetFloat("float"); !ok || f != 1.5 {
+		t.Errorf("GetFloat: got %f, %v", f, ok)
+	}
+
+	if f, ok := v.GetFloat("int"); !ok || f != -42 {
+		t.Errorf("GetFloat of int: got %f, %v", f, ok)
+	}
+
+	if b, ok := v.GetBool("bool"); !ok || !b {
+		t.Errorf("GetBool: got %v, %v", b, ok)
+	}
+
+	if tt, ok := v.GetTimestamp("time"); !ok || !tt.Equal(ts) {
+		t.Errorf("GetTimestamp: got %v, %v", tt, ok)
+	}
+
+	if got := v.Get("missing"); !got.IsZero() {
+		t.Errorf("Get missing: got %v", got)
+	}
+
+	if got := v.Get("str", "deeper"); !got.IsZero() {
+		t.Errorf("Get through non-map: got %v", got)
+	}
+}
@@ -135,3 +135,31 @@ func TestValue_BytesLen(t *testing.T) {
 		})
 	}
 }
+
+func ExampleValue_AppendJson() {
+	var buf Value
+
+	buf = AppendMapHeader(buf, 3)
+	buf = AppendString(buf, "foo")
+	buf = AppendString(buf, "say \"hi\"")
+	buf = AppendString(buf, "bar")
+	buf = AppendArrayHeader(buf, 2)
+	buf = AppendInt(buf, -1)
+	buf = AppendBool(buf, true)
+	buf = AppendString(buf, "baz")
+	buf = AppendMapHeader(buf, 1)
+	buf = AppendString(buf, "qux")
+	buf = AppendFloat(buf, 1.5)
+
+	b, err := buf.AppendJson(nil)
+
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Println(string(b))
+
+	// Output:
+	//
+	// {"foo":"say \"hi\"","bar":[-1,true],"baz":{"qux":1.5}}
+}
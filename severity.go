@@ -1,5 +1,11 @@
 package fluentlog
 
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
 type Severity uint8
 
 const (
@@ -12,3 +18,50 @@ const (
 	INFO
 	DEBUG
 )
+
+var severityStrings = [...]string{
+	"emerg",
+	"alert",
+	"crit",
+	"err",
+	"warn",
+	"notice",
+	"info",
+	"debug",
+}
+
+func (s Severity) String() string {
+	if int(s) >= len(severityStrings) {
+		return fmt.Sprintf("(invalid severity %d)", s)
+	}
+
+	return severityStrings[s]
+}
+
+// Parses a severity by its name (case-insensitive, e.g. "warn" or "warning") or number.
+func ParseSeverity(s string) (sev Severity, err error) {
+	s = strings.ToLower(s)
+
+	switch s {
+	case "emergency":
+		s = "emerg"
+	case "critical":
+		s = "crit"
+	case "error":
+		s = "err"
+	case "warning":
+		s = "warn"
+	}
+
+	for i, name := range severityStrings {
+		if s == name {
+			return Severity(i), nil
+		}
+	}
+
+	if n, err := strconv.ParseUint(s, 10, 8); err == nil && n < uint64(len(severityStrings)) {
+		return Severity(n), nil
+	}
+
+	return 0, fmt.Errorf("invalid severity '%s'", s)
+}